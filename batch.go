@@ -0,0 +1,270 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	common "dstet.me/p2m3u/common"
+	readers "dstet.me/p2m3u/readers"
+	writers "dstet.me/p2m3u/writers"
+)
+
+type BatchCmd struct {
+	Inputs        []string `arg:"" help:"Input playlists, directories of playlists, or globs"`
+	OutputDir     string   `short:"d" help:"Directory to write output playlists to" type:"path" default:"."`
+	NameTemplate  string   `help:"Output filename template. Available fields are {Name} (input name without extension) and {Ext} (output extension)" default:"{Name}.{Ext}"`
+	Scan          bool     `help:"Rescan configured search paths before converting"`
+	OutputMissing bool     `help:"Write a report of missing songs next to each output playlist"`
+	MissingFormat string   `help:"Format of the missing songs reports (csv or json)" enum:"csv,json" default:"csv"`
+	InputType     string   `short:"i" help:"Mode to parse input files, inferred from their extensions if unset: ${input_types}" optional:""`
+	OutputType    string   `short:"o" help:"Mode to write output files: ${output_types}" default:"M3U"`
+
+	OutputFlags `embed:""`
+}
+
+// A single playlist to convert in a batch.
+type batchInput struct {
+	Path string
+	// Playlist to read from inputs holding several, empty otherwise.
+	SourcePlaylist string
+}
+
+// Returns the name used for the input's output file and in messages.
+func (input batchInput) name() string {
+	if input.SourcePlaylist != "" {
+		return input.SourcePlaylist
+	}
+
+	return inputName(input.Path)
+}
+
+// Returns the input's path, followed by the playlist read from it if it holds several.
+func (input batchInput) description() string {
+	if input.SourcePlaylist != "" {
+		return input.Path + " (" + input.SourcePlaylist + ")"
+	}
+
+	return input.Path
+}
+
+// Splits inputs holding several playlists, like Spotify data exports, into one batch input per playlist.
+func expandPlaylists(files []string, inputType string) []batchInput {
+	var inputs []batchInput
+	for _, file := range files {
+		typeName := inputType
+		if typeName == "" {
+			typeName = common.GetFileExtension(file)
+		}
+
+		if reader, _, ok := readers.LookupReader(typeName); ok {
+			if multiReader, ok := reader.(readers.MultiPlaylistReader); ok {
+				if names, err := multiReader.PlaylistNames(file); err == nil && len(names) > 1 {
+					for _, name := range names {
+						inputs = append(inputs, batchInput{Path: file, SourcePlaylist: name})
+					}
+					continue
+				}
+			}
+		}
+
+		inputs = append(inputs, batchInput{Path: file})
+	}
+
+	return inputs
+}
+
+// Expands directories and globs into the list of input playlists, skipping duplicates.
+// Files inside directories are only included if their extension is a known input type.
+func expandInputs(inputs []string) ([]string, error) {
+	var files []string
+	for _, input := range inputs {
+		if info, err := os.Stat(input); err == nil && info.IsDir() {
+			entries, err := os.ReadDir(input)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, entry := range entries {
+				if !entry.IsDir() && isReadable(entry.Name()) {
+					files = append(files, filepath.Join(input, entry.Name()))
+				}
+			}
+			continue
+		}
+
+		matches, err := filepath.Glob(input)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+
+	var unique []string
+	for _, file := range files {
+		if !slices.Contains(unique, file) {
+			unique = append(unique, file)
+		}
+	}
+
+	return unique, nil
+}
+
+// Returns the path batch and watch write an input's output to.
+func inputOutputPath(input batchInput, outputDir string, template string, outputType string) string {
+	return filepath.Join(outputDir, outputName(template, input.name(), outputType))
+}
+
+// Returns true if the output type's writer adds to an existing file, so several inputs can share an output.
+func mergesOutput(outputType string) bool {
+	_, format, ok := writers.LookupWriter(outputType)
+	return ok && format.MergesOutput
+}
+
+// Returns the path of the missing songs report written next to an input's output playlist.
+// Outputs shared by several inputs get a report for each input, named after it.
+func missingReportPath(input batchInput, output string, outputType string, format string) string {
+	base := strings.TrimSuffix(output, filepath.Ext(output))
+	if mergesOutput(outputType) {
+		base = filepath.Join(filepath.Dir(output), outputName("{Name}", input.name(), outputType))
+	}
+
+	return base + ".missing." + format
+}
+
+// Removes inputs that are the output of any input, including their own, or its missing songs report.
+// Once outputs are readable, converting a directory into itself would otherwise read back and overwrite
+// its previous outputs. Returns the remaining inputs and the paths of those removed.
+func excludeOutputs(inputs []batchInput, outputDir string, template string, outputType string) ([]batchInput, []string) {
+	outputs := make(map[string]bool)
+	for _, input := range inputs {
+		output := inputOutputPath(input, outputDir, template, outputType)
+		outputs[absolutePath(output)] = true
+		// Reports of either format may be left from an earlier run, even if this one does not write them.
+		outputs[absolutePath(missingReportPath(input, output, outputType, "csv"))] = true
+		outputs[absolutePath(missingReportPath(input, output, outputType, "json"))] = true
+	}
+
+	var kept []batchInput
+	var excluded []string
+	for _, input := range inputs {
+		if outputs[absolutePath(input.Path)] {
+			if !slices.Contains(excluded, input.Path) {
+				excluded = append(excluded, input.Path)
+			}
+			continue
+		}
+		kept = append(kept, input)
+	}
+
+	return kept, excluded
+}
+
+// Returns the absolute form of a path, or the cleaned path if it cannot be made absolute.
+func absolutePath(path string) string {
+	if absPath, err := filepath.Abs(path); err == nil {
+		return absPath
+	}
+
+	return filepath.Clean(path)
+}
+
+// Returns true if a registered reader handles the file's extension.
+func isReadable(filename string) bool {
+	_, _, ok := readers.LookupReader(common.GetFileExtension(filename))
+	return ok
+}
+
+// Returns the input's file name without its extension.
+func inputName(input string) string {
+	return strings.TrimSuffix(filepath.Base(input), filepath.Ext(input))
+}
+
+// Builds an output filename from the template for the given input's playlist name.
+func outputName(template string, name string, outputType string) string {
+	// Playlist names from inside files may contain characters that are not allowed in file names.
+	name = strings.NewReplacer("/", "_", "\\", "_", ":", "_", "*", "_", "?", "_", "\"", "_", "<", "_", ">", "_", "|", "_").Replace(name)
+
+	// Types named differently from their files, like JELLYFIN, use their usual extension.
+	ext := outputType
+	if _, format, ok := writers.LookupWriter(outputType); ok && len(format.Extensions) > 0 {
+		ext = format.Extensions[0]
+	}
+
+	return strings.NewReplacer(
+		"{Name}", name,
+		"{Ext}", strings.ToLower(ext),
+	).Replace(template)
+}
+
+func (cmd *BatchCmd) Run(globals *Globals) error {
+	files, err := expandInputs(cmd.Inputs)
+	if err != nil {
+		return err
+	}
+	inputs, excluded := excludeOutputs(expandPlaylists(files, cmd.InputType), cmd.OutputDir, cmd.NameTemplate, cmd.OutputType)
+	for _, path := range excluded {
+		fmt.Println("Skipping", path+", which is an output of this batch")
+	}
+
+	if len(inputs) < 1 {
+		return errors.New("no input playlists found")
+	}
+
+	if err := os.MkdirAll(cmd.OutputDir, 0755); err != nil {
+		return err
+	}
+
+	config := loadConfig(globals, nil)
+	library, err := prepareLibrary(globals, &config, cmd.Scan)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	// Inputs with the same name in different directories or formats would overwrite each other's output,
+	// unless the writer adds each playlist to the existing file.
+	outputs := make(map[string]string)
+	merges := mergesOutput(cmd.OutputType)
+	for _, input := range inputs {
+		output := inputOutputPath(input, cmd.OutputDir, cmd.NameTemplate, cmd.OutputType)
+		if previous, exists := outputs[output]; exists && !merges {
+			fmt.Println("ERROR: Failed to convert", input.Path+":", output, "was already written for", previous)
+			failed++
+			continue
+		}
+		outputs[output] = input.Path
+
+		var outputMissing string
+		if cmd.OutputMissing {
+			outputMissing = missingReportPath(input, output, cmd.OutputType, cmd.MissingFormat)
+		}
+
+		err := convertPlaylist(conversion{
+			Input:          input.Path,
+			Output:         output,
+			InputType:      cmd.InputType,
+			OutputType:     cmd.OutputType,
+			OutputMissing:  outputMissing,
+			MissingFormat:  cmd.MissingFormat,
+			SourcePlaylist: input.SourcePlaylist,
+			OutputFlags:    cmd.OutputFlags,
+		}, &config, library)
+
+		// Keep going so one bad playlist does not stop the rest of the batch.
+		if err != nil {
+			fmt.Println("ERROR: Failed to convert", input.name()+":", err)
+			failed++
+		}
+	}
+
+	fmt.Println("Converted", len(inputs)-failed, "of", len(inputs), "playlists")
+	if failed > 0 {
+		return fmt.Errorf("%d playlists failed to convert", failed)
+	}
+
+	return nil
+}
@@ -0,0 +1,96 @@
+package common
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+)
+
+// Reads the bits per sample from the header of a FLAC, WAV or AIFF file.
+// Returns 0 and an error for other filetypes, since taglib does not expose bit depth.
+func ReadBitDepth(filename string, filetype string) (uint, error) {
+	switch filetype {
+	case "FLAC", "WAV", "AIF", "AIFF", "AIFC":
+	default:
+		return 0, errors.New("bit depth unsupported for " + filetype)
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	switch filetype {
+	case "FLAC":
+		return readFlacBitDepth(file)
+	case "WAV":
+		return readWavBitDepth(file)
+	default:
+		return readAiffBitDepth(file)
+	}
+}
+
+// The STREAMINFO block always comes first, directly after the "fLaC" marker.
+func readFlacBitDepth(file *os.File) (uint, error) {
+	header := make([]byte, 4+4+18)
+	if _, err := io.ReadFull(file, header); err != nil {
+		return 0, err
+	}
+
+	if !bytes.Equal(header[:4], []byte("fLaC")) || header[4]&0x7F != 0 {
+		return 0, errors.New("missing FLAC STREAMINFO")
+	}
+
+	// Bits per sample minus one is stored in 5 bits spanning bytes 12 and 13 of STREAMINFO.
+	streamInfo := header[8:]
+	bits := (uint(streamInfo[12]&0x01) << 4) | uint(streamInfo[13]>>4)
+	return bits + 1, nil
+}
+
+func readWavBitDepth(file *os.File) (uint, error) {
+	chunks, err := readWavChunks(file)
+	if err != nil {
+		return 0, err
+	}
+
+	for {
+		id, _, err := chunks.nextChunk()
+		if err != nil {
+			return 0, err
+		}
+
+		if id == "fmt " {
+			format, err := chunks.read(16)
+			if err != nil {
+				return 0, err
+			}
+			return uint(binary.LittleEndian.Uint16(format[14:16])), nil
+		}
+	}
+}
+
+func readAiffBitDepth(file *os.File) (uint, error) {
+	chunks, err := readAiffChunks(file)
+	if err != nil {
+		return 0, err
+	}
+
+	for {
+		id, _, err := chunks.nextChunk()
+		if err != nil {
+			return 0, err
+		}
+
+		if id == "COMM" {
+			// Channels (2 bytes) and frame count (4 bytes) come before the sample size.
+			comm, err := chunks.read(8)
+			if err != nil {
+				return 0, err
+			}
+			return uint(binary.BigEndian.Uint16(comm[6:8])), nil
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package common
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+)
+
+// Walks the chunks of a RIFF WAVE or AIFF file.
+type chunkReader struct {
+	file  *os.File
+	order binary.ByteOrder
+	// Form type from the file header: WAVE, AIFF or AIFC.
+	form string
+	// Offset of the chunk after the current one.
+	next int64
+}
+
+func readWavChunks(file *os.File) (*chunkReader, error) {
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(file, header); err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(header[:4], []byte("RIFF")) || !bytes.Equal(header[8:12], []byte("WAVE")) {
+		return nil, errors.New("not a RIFF WAVE file")
+	}
+
+	return &chunkReader{file: file, order: binary.LittleEndian, form: "WAVE", next: 12}, nil
+}
+
+func readAiffChunks(file *os.File) (*chunkReader, error) {
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(file, header); err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(header[:4], []byte("FORM")) {
+		return nil, errors.New("not an AIFF file")
+	}
+
+	return &chunkReader{file: file, order: binary.BigEndian, form: string(header[8:12]), next: 12}, nil
+}
+
+// Moves to the next chunk, skipping whatever was not read of the current one, and returns its id and size.
+func (chunks *chunkReader) nextChunk() (string, int64, error) {
+	if _, err := chunks.file.Seek(chunks.next, io.SeekStart); err != nil {
+		return "", 0, err
+	}
+
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(chunks.file, header); err != nil {
+		return "", 0, err
+	}
+
+	size := int64(chunks.order.Uint32(header[4:8]))
+	// Chunks are padded to an even number of bytes.
+	chunks.next += 8 + size + size%2
+	return string(header[:4]), size, nil
+}
+
+// Reads the next size bytes of the current chunk.
+func (chunks *chunkReader) read(size int64) ([]byte, error) {
+	data := make([]byte, size)
+	if _, err := io.ReadFull(chunks.file, data); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
@@ -1,13 +1,14 @@
 package common
 
 import (
-	"archive/zip"
-	"encoding/gob"
-	"errors"
 	"fmt"
-	"os"
+	"maps"
+	"path"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 )
 
 const AlbumArtistFormat = "AlbumArtist"
@@ -16,6 +17,7 @@ const ArtistFormat = "Artist"
 const TitleFormat = "Title"
 const TrackNumberFormat = "Track"
 const FormatSeparatorCharacter = "\u001E"
+const RootSeparatorCharacter = "\u001F"
 
 const ArtistMatchVal = 0.3
 const AlbumArtistMatchVal = 0.3
@@ -27,11 +29,78 @@ const UnknownArtist = "Unknown Artist"
 const ConverterDbFile = "converter.db"
 const ZippedFilename = "zipped"
 
+// Filetype used for Apple Lossless audio, which shares its extension with AAC.
+const AlacFiletype = "ALAC"
+
+// Every audio file extension taglib can read tags from.
+var SupportedFiletypes = []string{
+	"MP3",
+	"MP2",
+	"OGG",
+	"OGA",
+	"OPUS",
+	"SPX",
+	"FLAC",
+	"M4A",
+	"M4B",
+	"M4P",
+	"MP4",
+	"AAC",
+	"WAV",
+	"AIF",
+	"AIFF",
+	"AIFC",
+	"WMA",
+	"ASF",
+	"APE",
+	"WV",
+	"MPC",
+	"TTA",
+	"DSF",
+	"DFF",
+}
+
+// Filetypes that are stored without lossy compression.
+var LosslessFiletypes = []string{
+	"FLAC",
+	AlacFiletype,
+	"WAV",
+	"AIF",
+	"AIFF",
+	"AIFC",
+	"APE",
+	"WV",
+	"TTA",
+	"DSF",
+	"DFF",
+}
+
 var filetypeDefaultBonuses = map[string]float32{
-	"OGG":  0,
-	"MP3":  0,
-	"FLAC": 0.2,
-	"M4A":  0.1,
+	"OGG":        0,
+	"OGA":        0,
+	"SPX":        0,
+	"MP3":        0,
+	"MP2":        0,
+	"WMA":        0,
+	"ASF":        0,
+	"MPC":        0,
+	"OPUS":       0.1,
+	"AAC":        0.1,
+	"M4A":        0.1,
+	"M4B":        0.1,
+	"M4P":        0.1,
+	"MP4":        0.1,
+	"WAV":        0.15,
+	"AIF":        0.15,
+	"AIFF":       0.15,
+	"AIFC":       0.15,
+	"FLAC":       0.2,
+	AlacFiletype: 0.2,
+	"APE":        0.2,
+	"WV":         0.2,
+	"TTA":        0.2,
+	"DSF":        0.2,
+	"DFF":        0.2,
 }
 
 type ConverterConfig struct {
@@ -41,6 +110,32 @@ type ConverterConfig struct {
 	FiletypeBonuses       map[string]float32
 	SplitCharacters       []string
 	SpecialCases          []string
+	// File extensions to scan. ALAC files are scanned as M4A.
+	Filetypes []string
+	// Ordered criteria used to choose between duplicates with the same match score.
+	// Valid values are "lossless", "bitrate", "samplerate", "bitdepth", "channels", "newest" and "oldest".
+	QualityPreference []string
+	// Pattern used to infer metadata from a file's path when its tags are missing,
+	// e.g. "{AlbumArtist}/{Album}/{Track} - {Title}".
+	PathPattern string
+	// Glob patterns matched against each file's relpath. If any are given, only matching files are scanned.
+	IncludeGlobs []string
+	// Glob patterns for files and directories to skip while scanning, e.g. "*/Podcasts/*".
+	ExcludeGlobs []string
+	// Skips files and directories whose names start with a dot.
+	SkipHidden bool
+	// Descends into symlinked directories while scanning. Symlinked files are always scanned.
+	FollowSymlinks bool
+	// Fingerprints songs while scanning, from their ACOUSTID_FINGERPRINT tag or from decoded WAV, AIFF or FLAC audio.
+	Fingerprint bool
+	// Minimum fingerprint similarity (0 to 1) for two recordings to be considered the same.
+	FingerprintAllowance float32
+	// Rewrites the start of absolute song paths in output playlists when using the prefix path mode,
+	// e.g. "Z:/Music" = "/mnt/music".
+	PathPrefixes map[string]string
+	// Set when Paths comes from the config file, so songs under roots dropped from it are pruned when scanning.
+	// Search directories given on the command line are one-off additions and never cause pruning.
+	PathsFromConfig bool `toml:"-"`
 }
 
 func MakeConverterConfig() ConverterConfig {
@@ -48,200 +143,214 @@ func MakeConverterConfig() ConverterConfig {
 		Paths:                 nil,
 		Format:                ArtistFormat + FormatSeparatorCharacter + AlbumFormat + FormatSeparatorCharacter + TitleFormat,
 		MinimumMatchAllowance: 0.9,
-		FiletypeBonuses:       filetypeDefaultBonuses,
+		FiletypeBonuses:       maps.Clone(filetypeDefaultBonuses),
+		Filetypes:             slices.Clone(SupportedFiletypes),
+		QualityPreference:     []string{"lossless", "bitrate", "bitdepth", "samplerate"},
 		SplitCharacters:       []string{",", ";"},
 		SpecialCases:          nil,
+		PathPattern:           "",
+		IncludeGlobs:          nil,
+		ExcludeGlobs:          nil,
+		SkipHidden:            false,
+		FollowSymlinks:        false,
+		Fingerprint:           false,
+		FingerprintAllowance:  0.75,
+		PathPrefixes:          nil,
 	}
 }
 
+// Relpath is relative to the parent of the song's search root. It is always slash-separated,
+// writers render it with the separators of the target platform.
 type Song struct {
 	Filepath    string
 	Relpath     string
+	Root        string
 	Title       string
 	AlbumArtist string
 	Artist      string
 	Album       string
 	TrackNumber int
+	// Uppercase filetype used for bonuses. Usually the extension, but distinguishes codecs sharing one.
+	Filetype string
+	Duration time.Duration
+	// Bitrate in kbit/s.
+	Bitrate uint
+	// Sample rate in Hz.
+	SampleRate uint
+	// Bits per sample, only known for some lossless formats.
+	BitDepth uint
+	Channels uint
+	ModTime  time.Time
+	// Chromaprint fingerprint, only set when fingerprinting is enabled.
+	Fingerprint []uint32
 }
 
 func MakeSong() Song {
 	return Song{}
 }
 
-type ConverterLibrary struct {
-	// Map of internal song id to Song struct.
-	Songs map[int]*Song
-	// Map of Artists to list of songs.
-	ArtistsIndex map[string][]int
-	// Map of Album Artists to list of songs.
-	AlbumArtistsIndex map[string][]int
-	// Map of Albums to list of songs.
-	AlbumsIndex map[string][]int
-	// Map of Titles to list of songs.
-	TitlesIndex map[string][]int
-	// Song ids for indices.
-	Ids map[string]int
-	// Next id for id list.
-	NextId int
+// Returns the song's filetype, falling back to its extension for songs read before filetypes were stored.
+func (song *Song) GetFiletype() string {
+	if song.Filetype != "" {
+		return song.Filetype
+	}
+
+	return GetFileExtension(song.Filepath)
 }
 
-func MakeLibrary() ConverterLibrary {
-	return ConverterLibrary{
-		Songs:             make(map[int]*Song),
-		ArtistsIndex:      make(map[string][]int),
-		AlbumsIndex:       make(map[string][]int),
-		AlbumArtistsIndex: make(map[string][]int),
-		TitlesIndex:       make(map[string][]int),
-		Ids:               make(map[string]int),
-		NextId:            0,
-	}
+// Returns true if the song is stored in a lossless format.
+func (song *Song) IsLossless() bool {
+	return slices.Contains(LosslessFiletypes, song.GetFiletype())
 }
 
-// Reads ConverterLibrary from file specified.
-func (lib *ConverterLibrary) TryReadDbFile(file string) {
-	if file == "" {
-		file = ConverterDbFile
+// Helper function to compare two values where the larger is preferred.
+func preferLarger[T uint | int64](a T, b T) int {
+	if a > b {
+		return -1
+	} else if a < b {
+		return 1
 	}
+	return 0
+}
 
-	if _, err := os.Stat(file); err == nil {
-		fmt.Println("Existing db file found. Reading...")
-		zipR, err := zip.OpenReader(file)
-		if err != nil {
-			panic(err)
+// Compares two songs by the quality preference policy. Returns a negative number if a is preferred,
+// a positive number if b is preferred, or 0 if the policy does not distinguish them.
+func ComparePreference(a *Song, b *Song, policy []string) int {
+	for _, criterion := range policy {
+		var c int
+		switch strings.ToLower(criterion) {
+		case "lossless":
+			if a.IsLossless() != b.IsLossless() {
+				if a.IsLossless() {
+					c = -1
+				} else {
+					c = 1
+				}
+			}
+		case "bitrate":
+			c = preferLarger(a.Bitrate, b.Bitrate)
+		case "samplerate":
+			c = preferLarger(a.SampleRate, b.SampleRate)
+		case "bitdepth":
+			c = preferLarger(a.BitDepth, b.BitDepth)
+		case "channels":
+			c = preferLarger(a.Channels, b.Channels)
+		case "newest":
+			c = preferLarger(a.ModTime.Unix(), b.ModTime.Unix())
+		case "oldest":
+			c = -preferLarger(a.ModTime.Unix(), b.ModTime.Unix())
 		}
 
-		gobFile, err := zipR.Open(ZippedFilename)
-		if err != nil {
-			panic(err)
+		if c != 0 {
+			return c
 		}
-
-		decoder := gob.NewDecoder(gobFile)
-		decoder.Decode(&lib)
-		gobFile.Close()
-		zipR.Close()
-	} else if !errors.Is(err, os.ErrNotExist) {
-		panic(err)
 	}
+
+	return 0
 }
 
-// Writes ConverterLibrary to file specified.
-func (lib ConverterLibrary) WriteDbFile(file string) {
-	if file == "" {
-		file = ConverterDbFile
-	}
+var pathPatternPlaceholder = regexp.MustCompile(`\{([A-Za-z]+)\}`)
+
+// Compiles a path pattern such as "{AlbumArtist}/{Album}/{Track} - {Title}" into a regex
+// matched against the end of a song's relpath (without its extension).
+func CompilePathPattern(pattern string) (*regexp.Regexp, error) {
+	var reStr strings.Builder
+	reStr.WriteString(`(?:^|/)`)
+
+	last := 0
+	for _, match := range pathPatternPlaceholder.FindAllStringSubmatchIndex(pattern, -1) {
+		reStr.WriteString(regexp.QuoteMeta(pattern[last:match[0]]))
+
+		name := pattern[match[2]:match[3]]
+		switch name {
+		case TrackNumberFormat:
+			reStr.WriteString(`(?P<` + name + `>\d+)`)
+		case ArtistFormat, AlbumArtistFormat, AlbumFormat, TitleFormat:
+			reStr.WriteString(`(?P<` + name + `>[^/]+?)`)
+		default:
+			return nil, fmt.Errorf("unknown path pattern field {%s}", name)
+		}
 
-	zipFile, err := os.Create(file)
-	if err != nil {
-		panic(err)
+		last = match[1]
 	}
+	reStr.WriteString(regexp.QuoteMeta(pattern[last:]) + "$")
 
-	zipWriter := zip.NewWriter(zipFile)
+	return regexp.Compile(reStr.String())
+}
 
-	gobFile, err := zipWriter.Create(ZippedFilename)
-	if err != nil {
-		panic(err)
+// Compiles a glob pattern into a regex matched against slash-separated paths.
+// "*" and "?" do not cross directory separators while "**" does. Patterns are not anchored
+// to the start of the path, so they can match from any directory component.
+func CompileGlob(pattern string) (*regexp.Regexp, error) {
+	var reStr strings.Builder
+	reStr.WriteString(`(?:^|/)`)
+
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				reStr.WriteString(`.*`)
+				i++
+			} else {
+				reStr.WriteString(`[^/]*`)
+			}
+		case '?':
+			reStr.WriteString(`[^/]`)
+		default:
+			reStr.WriteString(regexp.QuoteMeta(string(pattern[i])))
+		}
 	}
-	encoder := gob.NewEncoder(gobFile)
-	encoder.Encode(lib)
+	reStr.WriteString("$")
 
-	zipWriter.Close()
-	zipFile.Close()
+	return regexp.Compile(reStr.String())
 }
 
-// Returns id of a song, otherwise returns -1.
-func (lib ConverterLibrary) GetId(path string) int {
-	if id, exists := lib.Ids[path]; exists {
-		return id
-	} else {
-		return -1
+// Fills any empty metadata fields on the song from its relpath using a compiled path pattern.
+// If no artist can be inferred but an album artist can, the album artist is used for both.
+func (song *Song) FillFromPath(pattern *regexp.Regexp) {
+	if pattern == nil {
+		return
 	}
-}
 
-// Returns new id for a new song.
-func (lib *ConverterLibrary) GetNewId(path string) int {
-	id := lib.NextId
-	lib.Ids[path] = id
-
-	lib.NextId += 1
+	relpath := strings.ReplaceAll(song.Relpath, "\\", "/")
+	relpath = strings.TrimSuffix(relpath, path.Ext(relpath))
+	match := pattern.FindStringSubmatch(relpath)
+	if match == nil {
+		return
+	}
 
-	return id
-}
+	for i, name := range pattern.SubexpNames() {
+		val := strings.TrimSpace(match[i])
+		if name == "" || val == "" {
+			continue
+		}
 
-// Helper function to return a list of possible matches.
-func (lib ConverterLibrary) getMatchCandidates(formatStr string, config *ConverterConfig) map[int]float32 {
-	// Use a map in place of a set (to avoid dupes).
-	candidateMap := make(map[int]float32)
-	splitFormatStr := strings.Split(formatStr, FormatSeparatorCharacter)
-
-	for i, split := range strings.Split(config.Format, FormatSeparatorCharacter) {
-		if split == ArtistFormat {
-			// Special case for artists, since there may be multiple.
-			for _, splitArtist := range ArtistSplit(splitFormatStr[i], config) {
-				trimmedArtist := strings.TrimSpace(splitArtist)
-				for _, candidate := range lib.ArtistsIndex[trimmedArtist] {
-					if val, present := candidateMap[candidate]; present {
-						candidateMap[candidate] = val + ArtistMatchVal
-					} else {
-						candidateMap[candidate] = ArtistMatchVal
-					}
-				}
+		switch name {
+		case ArtistFormat:
+			if song.Artist == "" {
+				song.Artist = val
 			}
-		} else if split == AlbumArtistFormat {
-			// Again, special case for artists, since there may be multiple.
-			for _, splitArtist := range ArtistSplit(splitFormatStr[i], config) {
-				for _, candidate := range lib.AlbumArtistsIndex[strings.TrimSpace(splitArtist)] {
-					if val, present := candidateMap[candidate]; present {
-						candidateMap[candidate] = val + AlbumArtistMatchVal
-					} else {
-						candidateMap[candidate] = AlbumArtistMatchVal
-					}
-				}
+		case AlbumArtistFormat:
+			if song.AlbumArtist == "" {
+				song.AlbumArtist = val
 			}
-		} else if split == AlbumFormat {
-			for _, candidate := range lib.AlbumsIndex[splitFormatStr[i]] {
-				if val, present := candidateMap[candidate]; present {
-					candidateMap[candidate] = val + AlbumMatchVal
-				} else {
-					candidateMap[candidate] = AlbumMatchVal
-				}
+		case AlbumFormat:
+			if song.Album == "" {
+				song.Album = val
 			}
-		} else if split == TitleFormat {
-			for _, candidate := range lib.TitlesIndex[splitFormatStr[i]] {
-				if val, present := candidateMap[candidate]; present {
-					candidateMap[candidate] = val + TitleMatchVal
-				} else {
-					candidateMap[candidate] = TitleMatchVal
-				}
+		case TitleFormat:
+			if song.Title == "" {
+				song.Title = val
+			}
+		case TrackNumberFormat:
+			if song.TrackNumber == 0 {
+				song.TrackNumber, _ = strconv.Atoi(val)
 			}
 		}
 	}
 
-	return candidateMap
-}
-
-// Function to get a Song ptr based on format string and ConverterConfig allowances.
-func (lib ConverterLibrary) GetSongFromFormatString(formatStr string, config *ConverterConfig) *Song {
-	candidates := lib.getMatchCandidates(formatStr, config)
-
-	var greatestVal float32
-	greatestVal = -1.0
-	currentCandidate := -1
-	for candidate, val := range candidates {
-		// Add any additional values based on the candidate (this can positively bias
-		// a specific version of a file in the case of dupes).
-		ext := GetFileExtension(lib.Songs[candidate].Filepath)
-		val += config.FiletypeBonuses[strings.ToUpper(ext)]
-
-		if val > greatestVal {
-			currentCandidate = candidate
-			greatestVal = val
-		}
-	}
-
-	if greatestVal > config.MinimumMatchAllowance {
-		return lib.Songs[currentCandidate]
-	} else {
-		return nil
+	if song.Artist == "" {
+		song.Artist = song.AlbumArtist
 	}
 }
 
@@ -0,0 +1,445 @@
+package common
+
+import (
+	"encoding/base64"
+	"errors"
+	"math"
+	"math/bits"
+	"math/cmplx"
+)
+
+// Parameters of Chromaprint's default algorithm (TEST2), which this implementation follows.
+const fingerprintSampleRate = 11025
+const fingerprintFrameSize = 4096
+const fingerprintFrameStep = fingerprintFrameSize / 3
+const fingerprintMinFreq = 28
+const fingerprintMaxFreq = 3520
+const fingerprintAlgorithm = 1
+
+// Number of seconds of audio fingerprinted, matching fpcalc's default.
+const FingerprintDuration = 120
+
+// Maximum number of items two fingerprints are shifted against each other when compared (about 4 seconds).
+const fingerprintMaxOffset = 32
+
+var chromaFilterCoefficients = []float64{0.25, 0.75, 1.0, 0.75, 0.25}
+
+type fingerprintFilter struct {
+	kind   int
+	y      int
+	height int
+	width  int
+}
+
+type fingerprintClassifier struct {
+	filter    fingerprintFilter
+	quantizer [3]float64
+}
+
+var fingerprintClassifiers = []fingerprintClassifier{
+	{fingerprintFilter{0, 4, 3, 15}, [3]float64{1.98215, 2.35817, 2.63523}},
+	{fingerprintFilter{4, 4, 6, 15}, [3]float64{-1.03809, -0.651211, -0.282167}},
+	{fingerprintFilter{1, 0, 4, 16}, [3]float64{-0.298702, 0.119262, 0.558497}},
+	{fingerprintFilter{3, 8, 2, 12}, [3]float64{-0.105439, 0.0153946, 0.135898}},
+	{fingerprintFilter{3, 4, 4, 8}, [3]float64{-0.142891, 0.0258736, 0.200632}},
+	{fingerprintFilter{4, 0, 3, 5}, [3]float64{-0.826319, -0.590612, -0.368214}},
+	{fingerprintFilter{1, 2, 2, 9}, [3]float64{-0.557409, -0.233035, 0.0534525}},
+	{fingerprintFilter{2, 7, 3, 4}, [3]float64{-0.0646826, 0.00620476, 0.0784847}},
+	{fingerprintFilter{2, 6, 2, 16}, [3]float64{-0.192387, -0.029699, 0.215855}},
+	{fingerprintFilter{2, 1, 3, 2}, [3]float64{-0.0397818, -0.00568076, 0.0292026}},
+	{fingerprintFilter{5, 10, 1, 15}, [3]float64{-0.53823, -0.369934, -0.190235}},
+	{fingerprintFilter{3, 6, 2, 10}, [3]float64{-0.124877, 0.0296483, 0.139239}},
+	{fingerprintFilter{2, 1, 1, 14}, [3]float64{-0.101475, 0.0225617, 0.231971}},
+	{fingerprintFilter{3, 5, 6, 4}, [3]float64{-0.0799915, -0.00729616, 0.063262}},
+	{fingerprintFilter{1, 9, 2, 12}, [3]float64{-0.272556, 0.019424, 0.302559}},
+	{fingerprintFilter{3, 4, 2, 14}, [3]float64{-0.164292, -0.0321188, 0.0846339}},
+}
+
+// Computes a Chromaprint style fingerprint from mono audio. The result uses the same
+// format and algorithm as fpcalc, but resampling differs so values are close rather than identical.
+func ComputeFingerprint(audio MonoAudio) []uint32 {
+	samples := resample(audio.Samples, audio.SampleRate, fingerprintSampleRate)
+
+	window := make([]float64, fingerprintFrameSize)
+	for i := range window {
+		window[i] = 0.54 - 0.46*math.Cos(2*math.Pi*float64(i)/float64(fingerprintFrameSize-1))
+	}
+
+	// Map each FFT bin in range to one of the 12 chroma bands.
+	minIndex := max(1, int(math.Round(fingerprintFrameSize*fingerprintMinFreq/float64(fingerprintSampleRate))))
+	maxIndex := min(fingerprintFrameSize/2, int(math.Round(fingerprintFrameSize*fingerprintMaxFreq/float64(fingerprintSampleRate))))
+	notes := make([]int, maxIndex)
+	for i := minIndex; i < maxIndex; i++ {
+		freq := float64(i) * fingerprintSampleRate / fingerprintFrameSize
+		octave := math.Log2(freq / (440.0 / 16.0))
+		notes[i] = int(12 * (octave - math.Floor(octave)))
+	}
+
+	var chromas [][12]float64
+	frame := make([]complex128, fingerprintFrameSize)
+	for start := 0; start+fingerprintFrameSize <= len(samples); start += fingerprintFrameStep {
+		for i := range frame {
+			frame[i] = complex(samples[start+i]*window[i], 0)
+		}
+		fft(frame)
+
+		var chroma [12]float64
+		for i := minIndex; i < maxIndex; i++ {
+			chroma[notes[i]] += real(frame[i])*real(frame[i]) + imag(frame[i])*imag(frame[i])
+		}
+		chromas = append(chromas, chroma)
+	}
+
+	// Smooth each band over time, then normalize every frame.
+	var image [][12]float64
+	for t := 0; t+len(chromaFilterCoefficients) <= len(chromas); t++ {
+		var filtered [12]float64
+		for j, coefficient := range chromaFilterCoefficients {
+			for band := range filtered {
+				filtered[band] += coefficient * chromas[t+j][band]
+			}
+		}
+
+		var norm float64
+		for _, v := range filtered {
+			norm += v * v
+		}
+		norm = math.Sqrt(norm)
+
+		for band := range filtered {
+			if norm < 0.01 {
+				filtered[band] = 0
+			} else {
+				filtered[band] /= norm
+			}
+		}
+		image = append(image, filtered)
+	}
+
+	integral := makeIntegralImage(image)
+	maxWidth := 0
+	for _, classifier := range fingerprintClassifiers {
+		maxWidth = max(maxWidth, classifier.filter.width)
+	}
+
+	var fingerprint []uint32
+	grayCode := []uint32{0, 1, 3, 2}
+	for offset := 0; offset+maxWidth <= len(image); offset++ {
+		var bits uint32
+		for _, classifier := range fingerprintClassifiers {
+			value := integral.apply(classifier.filter, offset)
+			bits = (bits << 2) | grayCode[quantize(value, classifier.quantizer)]
+		}
+		fingerprint = append(fingerprint, bits)
+	}
+
+	return fingerprint
+}
+
+// Number of zero crossings on each side of the resampling filter.
+const resampleTaps = 16
+
+// Resolution of the precomputed resampling kernel, in entries per zero crossing.
+const resampleKernelResolution = 256
+
+// Blackman windowed sinc, precomputed since evaluating it per tap dominates fingerprinting time.
+var resampleKernel = func() []float64 {
+	kernel := make([]float64, resampleTaps*resampleKernelResolution+2)
+	for i := range kernel {
+		x := float64(i) / resampleKernelResolution
+		if x > resampleTaps {
+			continue
+		}
+
+		sinc := 1.0
+		if x != 0 {
+			sinc = math.Sin(math.Pi*x) / (math.Pi * x)
+		}
+		window := 0.42 + 0.5*math.Cos(math.Pi*x/resampleTaps) + 0.08*math.Cos(2*math.Pi*x/resampleTaps)
+		kernel[i] = sinc * window
+	}
+	return kernel
+}()
+
+// Resamples using a windowed sinc filter, which also low-passes when downsampling.
+func resample(samples []float64, fromRate int, toRate int) []float64 {
+	if fromRate == toRate {
+		return samples
+	}
+
+	const taps = resampleTaps
+	ratio := float64(fromRate) / float64(toRate)
+	cutoff := min(1.0, 1/ratio)
+	halfWidth := float64(taps) / cutoff
+
+	output := make([]float64, int(float64(len(samples))/ratio))
+	for i := range output {
+		center := float64(i) * ratio
+		first := max(0, int(math.Ceil(center-halfWidth)))
+		last := min(len(samples)-1, int(math.Floor(center+halfWidth)))
+
+		var sum float64
+		for j := first; j <= last; j++ {
+			// Linearly interpolate the kernel at |x|.
+			position := math.Abs(float64(j)-center) * cutoff * resampleKernelResolution
+			index := int(position)
+			frac := position - float64(index)
+			sum += samples[j] * (resampleKernel[index]*(1-frac) + resampleKernel[index+1]*frac)
+		}
+		output[i] = sum * cutoff
+	}
+
+	return output
+}
+
+// In-place iterative radix-2 FFT. len(data) must be a power of two.
+func fft(data []complex128) {
+	n := len(data)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			data[i], data[j] = data[j], data[i]
+		}
+	}
+
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Exp(complex(0, -2*math.Pi/float64(size)))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				even := data[start+k]
+				odd := data[start+k+size/2] * w
+				data[start+k] = even + odd
+				data[start+k+size/2] = even - odd
+				w *= step
+			}
+		}
+	}
+}
+
+func quantize(value float64, thresholds [3]float64) int {
+	if value < thresholds[1] {
+		if value < thresholds[0] {
+			return 0
+		}
+		return 1
+	} else if value < thresholds[2] {
+		return 2
+	}
+	return 3
+}
+
+// Summed area table over time (rows) and chroma bands (columns).
+type integralImage [][13]float64
+
+func makeIntegralImage(image [][12]float64) integralImage {
+	integral := make(integralImage, len(image)+1)
+	for row := range image {
+		for col := range 12 {
+			integral[row+1][col+1] = image[row][col] + integral[row][col+1] + integral[row+1][col] - integral[row][col]
+		}
+	}
+
+	return integral
+}
+
+// Returns the sum of rows [r1, r2) and columns [c1, c2).
+func (integral integralImage) area(r1 int, c1 int, r2 int, c2 int) float64 {
+	return integral[r2][c2] - integral[r1][c2] - integral[r2][c1] + integral[r1][c1]
+}
+
+func subtractLog(a float64, b float64) float64 {
+	return math.Log(1+a) - math.Log(1+b)
+}
+
+func (integral integralImage) apply(filter fingerprintFilter, x int) float64 {
+	y, w, h := filter.y, filter.width, filter.height
+	switch filter.kind {
+	case 0:
+		return subtractLog(integral.area(x, y, x+w, y+h), 0)
+	case 1:
+		h2 := h / 2
+		return subtractLog(integral.area(x, y+h2, x+w, y+h), integral.area(x, y, x+w, y+h2))
+	case 2:
+		w2 := w / 2
+		return subtractLog(integral.area(x+w2, y, x+w, y+h), integral.area(x, y, x+w2, y+h))
+	case 3:
+		w2, h2 := w/2, h/2
+		a := integral.area(x, y+h2, x+w2, y+h) + integral.area(x+w2, y, x+w, y+h2)
+		b := integral.area(x, y, x+w2, y+h2) + integral.area(x+w2, y+h2, x+w, y+h)
+		return subtractLog(a, b)
+	case 4:
+		h3 := h / 3
+		a := integral.area(x, y+h3, x+w, y+2*h3)
+		b := integral.area(x, y, x+w, y+h3) + integral.area(x, y+2*h3, x+w, y+h)
+		return subtractLog(a, b)
+	default:
+		w3 := w / 3
+		a := integral.area(x+w3, y, x+2*w3, y+h)
+		b := integral.area(x, y, x+w3, y+h) + integral.area(x+2*w3, y, x+w, y+h)
+		return subtractLog(a, b)
+	}
+}
+
+// Packs values of a fixed bit width least significant bit first, as Chromaprint does.
+type bitWriter struct {
+	data []byte
+	bits int
+}
+
+func (writer *bitWriter) write(value uint32, width int) {
+	for i := range width {
+		if writer.bits%8 == 0 {
+			writer.data = append(writer.data, 0)
+		}
+		if value&(1<<i) != 0 {
+			writer.data[len(writer.data)-1] |= 1 << (writer.bits % 8)
+		}
+		writer.bits++
+	}
+}
+
+type bitReader struct {
+	data []byte
+	bits int
+}
+
+func (reader *bitReader) read(width int) (uint32, bool) {
+	var value uint32
+	for i := range width {
+		if reader.bits/8 >= len(reader.data) {
+			return 0, false
+		}
+		if reader.data[reader.bits/8]&(1<<(reader.bits%8)) != 0 {
+			value |= 1 << i
+		}
+		reader.bits++
+	}
+	return value, true
+}
+
+// Encodes a fingerprint in Chromaprint's compressed, URL safe base64 format, as stored in
+// ACOUSTID_FINGERPRINT tags and printed by fpcalc.
+func EncodeFingerprint(fingerprint []uint32) string {
+	// Each item is XORed with the previous one and stored as the gaps between its set bits.
+	var normal []uint32
+	var previous uint32
+	for _, item := range fingerprint {
+		x := item ^ previous
+		previous = item
+
+		var bit, lastBit uint32 = 1, 0
+		for ; x != 0; x >>= 1 {
+			if x&1 != 0 {
+				normal = append(normal, bit-lastBit)
+				lastBit = bit
+			}
+			bit++
+		}
+		normal = append(normal, 0)
+	}
+
+	header := []byte{fingerprintAlgorithm, byte(len(fingerprint) >> 16), byte(len(fingerprint) >> 8), byte(len(fingerprint))}
+
+	var normalBits, exceptionalBits bitWriter
+	for _, value := range normal {
+		// Gaps too large for 3 bits store the remainder in the exceptional stream.
+		if value >= 7 {
+			normalBits.write(7, 3)
+			exceptionalBits.write(value-7, 5)
+		} else {
+			normalBits.write(value, 3)
+		}
+	}
+
+	encoded := append(header, normalBits.data...)
+	encoded = append(encoded, exceptionalBits.data...)
+	return base64.RawURLEncoding.EncodeToString(encoded)
+}
+
+// Decodes a fingerprint from Chromaprint's compressed format.
+func DecodeFingerprint(encoded string) ([]uint32, error) {
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < 4 {
+		return nil, errors.New("fingerprint too short")
+	}
+	length := int(data[1])<<16 | int(data[2])<<8 | int(data[3])
+
+	normalBits := bitReader{data: data[4:]}
+	var normal []uint32
+	for items := 0; items < length; {
+		value, ok := normalBits.read(3)
+		if !ok {
+			return nil, errors.New("fingerprint truncated")
+		}
+		if value == 0 {
+			items++
+		}
+		normal = append(normal, value)
+	}
+
+	// The exceptional stream starts at the byte following the normal stream.
+	exceptionalBits := bitReader{data: data[4+(normalBits.bits+7)/8:]}
+	fingerprint := make([]uint32, 0, length)
+	var item, previous uint32
+	var bit uint32
+	for _, value := range normal {
+		if value == 0 {
+			previous ^= item
+			fingerprint = append(fingerprint, previous)
+			item, bit = 0, 0
+			continue
+		}
+
+		if value == 7 {
+			extra, ok := exceptionalBits.read(5)
+			if !ok {
+				return nil, errors.New("fingerprint truncated")
+			}
+			value += extra
+		}
+
+		bit += value
+		if bit < 1 || bit > 32 {
+			return nil, errors.New("invalid fingerprint bit position")
+		}
+		item |= 1 << (bit - 1)
+	}
+
+	return fingerprint, nil
+}
+
+// Returns how similar two fingerprints are, from 0 to 1, as one minus the bit error rate
+// of their best alignment within a few seconds.
+func FingerprintSimilarity(a []uint32, b []uint32) float32 {
+	if len(a) < 1 || len(b) < 1 {
+		return 0
+	}
+
+	minOverlap := max(1, min(len(a), len(b))/2)
+	var best float32
+	for offset := -fingerprintMaxOffset; offset <= fingerprintMaxOffset; offset++ {
+		errorBits, overlap := 0, 0
+		for i := max(0, -offset); i < len(a) && i+offset < len(b); i++ {
+			errorBits += bits.OnesCount32(a[i] ^ b[i+offset])
+			overlap++
+		}
+
+		if overlap < minOverlap {
+			continue
+		}
+
+		similarity := 1 - float32(errorBits)/float32(32*overlap)
+		best = max(best, similarity)
+	}
+
+	return best
+}
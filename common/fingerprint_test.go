@@ -0,0 +1,83 @@
+package common
+
+import (
+	"math"
+	"slices"
+	"testing"
+)
+
+func TestFingerprintRoundTrip(t *testing.T) {
+	fingerprints := [][]uint32{
+		{},
+		{0},
+		{1, 1, 0x80000000, 0xFFFFFFFF, 0},
+		{0xDEADBEEF, 0x12345678, 0x0F0F0F0F, 0xF0F0F0F0, 0x00010001},
+	}
+
+	for _, fingerprint := range fingerprints {
+		encoded := EncodeFingerprint(fingerprint)
+		decoded, err := DecodeFingerprint(encoded)
+		if err != nil {
+			t.Errorf("failed to decode %q: %v", encoded, err)
+			continue
+		}
+		if !slices.Equal(decoded, fingerprint) {
+			t.Errorf("round trip of %08x gave %08x", fingerprint, decoded)
+		}
+	}
+}
+
+// Uses the layout fpcalc writes: the algorithm byte, a 24 bit item count, then the 3 bit gap stream
+// followed by the 5 bit exceptional stream, both packed least significant bit first.
+func TestDecodeFingerprintKnownString(t *testing.T) {
+	// Items 0x801 and 0x80000801 store the gaps 1, 7+4, end, 7+25, end.
+	// Normal stream: 0x39 0x0E. Exceptional stream: 0x24 0x03.
+	const encoded = "AQAAAjkOJAM"
+	expected := []uint32{0x801, 0x80000801}
+
+	decoded, err := DecodeFingerprint(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(decoded, expected) {
+		t.Errorf("expected %08x, got %08x", expected, decoded)
+	}
+
+	if reencoded := EncodeFingerprint(expected); reencoded != encoded {
+		t.Errorf("expected %q, got %q", encoded, reencoded)
+	}
+}
+
+func TestDecodeFingerprintTruncated(t *testing.T) {
+	for _, encoded := range []string{"", "AQAA", "AQAAAjkO"} {
+		if _, err := DecodeFingerprint(encoded); err == nil {
+			t.Errorf("expected an error decoding %q", encoded)
+		}
+	}
+}
+
+func TestFingerprintSimilarity(t *testing.T) {
+	samples := make([]float64, 30*fingerprintSampleRate)
+	for i := range samples {
+		seconds := float64(i) / fingerprintSampleRate
+		// A tone changing pitch every second, so the fingerprint is not constant.
+		freq := 220 * math.Pow(2, float64(int(seconds)%12)/12)
+		samples[i] = 10000 * math.Sin(2*math.Pi*freq*seconds)
+	}
+
+	fingerprint := ComputeFingerprint(MonoAudio{Samples: samples, SampleRate: fingerprintSampleRate})
+	if len(fingerprint) < 1 {
+		t.Fatal("no fingerprint computed")
+	}
+
+	if similarity := FingerprintSimilarity(fingerprint, fingerprint); similarity != 1 {
+		t.Errorf("expected a fingerprint to match itself exactly, got %f", similarity)
+	}
+	// Dropping the first items shifts the alignment, which should still be found.
+	if similarity := FingerprintSimilarity(fingerprint, fingerprint[10:]); similarity != 1 {
+		t.Errorf("expected a shifted fingerprint to match, got %f", similarity)
+	}
+	if similarity := FingerprintSimilarity(fingerprint, nil); similarity != 0 {
+		t.Errorf("expected no similarity with an empty fingerprint, got %f", similarity)
+	}
+}
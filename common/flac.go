@@ -0,0 +1,560 @@
+package common
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"math"
+	"math/bits"
+	"os"
+)
+
+// Reads bits most significant first, as FLAC stores them.
+type flacBitReader struct {
+	reader *bufio.Reader
+	cache  uint64
+	bits   uint
+}
+
+func (reader *flacBitReader) read(width uint) (uint64, error) {
+	for reader.bits < width {
+		b, err := reader.reader.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		reader.cache = reader.cache<<8 | uint64(b)
+		reader.bits += 8
+	}
+
+	reader.bits -= width
+	value := reader.cache >> reader.bits
+	reader.cache &= 1<<reader.bits - 1
+	return value, nil
+}
+
+// Reads a two's complement number of the given width.
+func (reader *flacBitReader) readSigned(width uint) (int64, error) {
+	value, err := reader.read(width)
+	if err != nil || width == 0 {
+		return 0, err
+	}
+	return int64(value<<(64-width)) >> (64 - width), nil
+}
+
+// Counts zero bits up to and including the next set bit.
+func (reader *flacBitReader) readUnary() (uint64, error) {
+	var count uint64
+	for {
+		if reader.bits == 0 {
+			b, err := reader.reader.ReadByte()
+			if err != nil {
+				return 0, err
+			}
+			reader.cache, reader.bits = uint64(b), 8
+		}
+
+		if reader.cache == 0 {
+			count += uint64(reader.bits)
+			reader.bits = 0
+			continue
+		}
+
+		high := uint(bits.Len64(reader.cache)) - 1
+		count += uint64(reader.bits - 1 - high)
+		reader.bits = high
+		reader.cache &= 1<<high - 1
+		return count, nil
+	}
+}
+
+// Discards the bits left in the current byte.
+func (reader *flacBitReader) align() {
+	reader.cache, reader.bits = 0, 0
+}
+
+// Stream parameters from a FLAC file's STREAMINFO block.
+type flacStreamInfo struct {
+	sampleRate int
+	channels   int
+	bitDepth   int
+}
+
+// Reads up to maxDuration seconds of a FLAC file, mixed down to mono.
+func readFlacPcm(file *os.File, maxDuration float64) (MonoAudio, error) {
+	reader := &flacBitReader{reader: bufio.NewReader(file)}
+
+	if err := skipId3(reader.reader); err != nil {
+		return MonoAudio{}, err
+	}
+
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(reader.reader, magic); err != nil {
+		return MonoAudio{}, err
+	}
+	if string(magic) != "fLaC" {
+		return MonoAudio{}, errors.New("not a FLAC file")
+	}
+
+	info, err := readFlacMetadata(reader)
+	if err != nil {
+		return MonoAudio{}, err
+	}
+
+	maxSamples := math.MaxInt
+	if maxDuration > 0 {
+		maxSamples = int(maxDuration * float64(info.sampleRate))
+	}
+
+	// Samples are scaled to the range of a 16 bit integer, like decodePcmSample.
+	scale := math.Pow(2, float64(16-info.bitDepth))
+	var samples []float64
+	for len(samples) < maxSamples {
+		channels, err := readFlacFrame(reader, info)
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			// Keep what was decoded from a damaged or truncated file, as readPcmSamples does.
+			if len(samples) > 0 {
+				break
+			}
+			return MonoAudio{}, err
+		}
+
+		for i := range channels[0] {
+			var sum float64
+			for _, channel := range channels {
+				sum += float64(channel[i])
+			}
+			samples = append(samples, sum/float64(len(channels))*scale)
+		}
+	}
+
+	if len(samples) > maxSamples {
+		samples = samples[:maxSamples]
+	}
+
+	return MonoAudio{Samples: samples, SampleRate: info.sampleRate}, nil
+}
+
+// Skips an ID3v2 tag, which some taggers put in front of FLAC files.
+func skipId3(reader *bufio.Reader) error {
+	header, err := reader.Peek(10)
+	if err != nil || string(header[:3]) != "ID3" {
+		return nil
+	}
+
+	// The tag size is stored as four 7 bit bytes.
+	size := int(header[6])<<21 | int(header[7])<<14 | int(header[8])<<7 | int(header[9])
+	size += 10
+	if header[5]&0x10 != 0 {
+		size += 10
+	}
+
+	_, err = reader.Discard(size)
+	return err
+}
+
+// Reads the metadata blocks, returning the stream parameters and leaving the reader at the first frame.
+func readFlacMetadata(reader *flacBitReader) (flacStreamInfo, error) {
+	var info flacStreamInfo
+	for {
+		header, err := reader.read(32)
+		if err != nil {
+			return info, err
+		}
+
+		last := header>>31 != 0
+		blockType := header >> 24 & 0x7F
+		length := int(header & 0xFFFFFF)
+
+		if blockType == 0 {
+			if length < 18 {
+				return info, errors.New("FLAC STREAMINFO block too short")
+			}
+
+			// Block sizes and frame sizes are not needed, as every frame header repeats its own.
+			if _, err := reader.read(32); err != nil {
+				return info, err
+			}
+			if _, err := reader.read(48); err != nil {
+				return info, err
+			}
+			params, err := reader.read(28)
+			if err != nil {
+				return info, err
+			}
+			info = flacStreamInfo{
+				sampleRate: int(params >> 8),
+				channels:   int(params>>5&0x7) + 1,
+				bitDepth:   int(params&0x1F) + 1,
+			}
+			// The rest of the block, starting with the last 4 bits of the current byte, is skipped.
+			reader.align()
+			length -= 14
+		}
+
+		if _, err := reader.reader.Discard(length); err != nil {
+			return info, err
+		}
+
+		if last {
+			break
+		}
+	}
+
+	if info.sampleRate < 1 {
+		return info, errors.New("FLAC file has no STREAMINFO block")
+	}
+	return info, nil
+}
+
+// Reads one frame, returning the decoded samples of each channel.
+func readFlacFrame(reader *flacBitReader, info flacStreamInfo) ([][]int32, error) {
+	sync, err := reader.read(16)
+	if err != nil {
+		return nil, err
+	}
+	if sync>>2 != 0x3FFE {
+		return nil, errors.New("lost FLAC frame sync")
+	}
+
+	codes, err := reader.read(16)
+	if err != nil {
+		return nil, err
+	}
+	blockSizeCode := codes >> 12
+	sampleRateCode := codes >> 8 & 0xF
+	channelAssignment := int(codes >> 4 & 0xF)
+	bitDepthCode := codes >> 1 & 0x7
+
+	// The frame or sample number is UTF-8 style coded, with its length in the leading ones.
+	first, err := reader.read(8)
+	if err != nil {
+		return nil, err
+	}
+	for extra := bits.LeadingZeros8(^uint8(first)) - 1; extra > 0; extra-- {
+		if _, err := reader.read(8); err != nil {
+			return nil, err
+		}
+	}
+
+	var blockSize int
+	switch {
+	case blockSizeCode == 1:
+		blockSize = 192
+	case blockSizeCode >= 2 && blockSizeCode <= 5:
+		blockSize = 576 << (blockSizeCode - 2)
+	case blockSizeCode == 6:
+		size, err := reader.read(8)
+		if err != nil {
+			return nil, err
+		}
+		blockSize = int(size) + 1
+	case blockSizeCode == 7:
+		size, err := reader.read(16)
+		if err != nil {
+			return nil, err
+		}
+		blockSize = int(size) + 1
+	case blockSizeCode >= 8:
+		blockSize = 256 << (blockSizeCode - 8)
+	default:
+		return nil, errors.New("invalid FLAC block size")
+	}
+
+	// The sample rate is taken from STREAMINFO, but its header fields still need skipping.
+	if sampleRateCode == 12 {
+		_, err = reader.read(8)
+	} else if sampleRateCode == 13 || sampleRateCode == 14 {
+		_, err = reader.read(16)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	bitDepth := info.bitDepth
+	switch bitDepthCode {
+	case 1:
+		bitDepth = 8
+	case 2:
+		bitDepth = 12
+	case 4:
+		bitDepth = 16
+	case 5:
+		bitDepth = 20
+	case 6:
+		bitDepth = 24
+	case 7:
+		bitDepth = 32
+	}
+
+	// Header CRC-8.
+	if _, err := reader.read(8); err != nil {
+		return nil, err
+	}
+
+	channelCount := channelAssignment + 1
+	if channelAssignment >= 8 && channelAssignment <= 10 {
+		channelCount = 2
+	} else if channelAssignment > 10 {
+		return nil, errors.New("invalid FLAC channel assignment")
+	}
+
+	channels := make([][]int32, channelCount)
+	for i := range channels {
+		// The side channel of stereo decorrelation needs an extra bit.
+		subframeDepth := bitDepth
+		if (channelAssignment == 8 || channelAssignment == 10) && i == 1 || channelAssignment == 9 && i == 0 {
+			subframeDepth++
+		}
+
+		channels[i], err = readFlacSubframe(reader, blockSize, subframeDepth)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	left, right := channels[0], channels[len(channels)-1]
+	switch channelAssignment {
+	case 8:
+		for i := range right {
+			right[i] = left[i] - right[i]
+		}
+	case 9:
+		for i := range left {
+			left[i] += right[i]
+		}
+	case 10:
+		for i := range left {
+			mid := int64(left[i])<<1 | int64(right[i])&1
+			side := int64(right[i])
+			left[i] = int32((mid + side) >> 1)
+			right[i] = int32((mid - side) >> 1)
+		}
+	}
+
+	// Frame footer CRC-16.
+	reader.align()
+	if _, err := reader.read(16); err != nil {
+		return nil, err
+	}
+
+	// Samples mixed down to mono are scaled by the stream depth, so frames using another depth are rescaled.
+	if bitDepth != info.bitDepth {
+		for _, channel := range channels {
+			for i := range channel {
+				channel[i] = int32(float64(channel[i]) * math.Pow(2, float64(info.bitDepth-bitDepth)))
+			}
+		}
+	}
+
+	return channels, nil
+}
+
+func readFlacSubframe(reader *flacBitReader, blockSize int, bitDepth int) ([]int32, error) {
+	header, err := reader.read(8)
+	if err != nil {
+		return nil, err
+	}
+	if header&0x80 != 0 {
+		return nil, errors.New("invalid FLAC subframe header")
+	}
+
+	// Bits that are zero in every sample are removed before coding.
+	wasted := 0
+	if header&1 != 0 {
+		count, err := reader.readUnary()
+		if err != nil {
+			return nil, err
+		}
+		wasted = int(count) + 1
+		bitDepth -= wasted
+	}
+	if bitDepth < 1 {
+		return nil, errors.New("invalid FLAC sample depth")
+	}
+
+	samples := make([]int64, blockSize)
+	subframeType := header >> 1 & 0x3F
+	switch {
+	case subframeType == 0:
+		value, err := reader.readSigned(uint(bitDepth))
+		if err != nil {
+			return nil, err
+		}
+		for i := range samples {
+			samples[i] = value
+		}
+	case subframeType == 1:
+		for i := range samples {
+			if samples[i], err = reader.readSigned(uint(bitDepth)); err != nil {
+				return nil, err
+			}
+		}
+	case subframeType >= 8 && subframeType <= 12:
+		if err := readFlacFixed(reader, samples, int(subframeType&0x7), bitDepth); err != nil {
+			return nil, err
+		}
+	case subframeType >= 32:
+		if err := readFlacLpc(reader, samples, int(subframeType&0x1F)+1, bitDepth); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("invalid FLAC subframe type")
+	}
+
+	decoded := make([]int32, blockSize)
+	for i, sample := range samples {
+		decoded[i] = int32(sample << wasted)
+	}
+	return decoded, nil
+}
+
+// Reads the unencoded warm up samples that start predicted subframes.
+func readFlacWarmup(reader *flacBitReader, samples []int64, order int, bitDepth int) error {
+	if order > len(samples) {
+		return errors.New("FLAC predictor order larger than block")
+	}
+
+	for i := range order {
+		sample, err := reader.readSigned(uint(bitDepth))
+		if err != nil {
+			return err
+		}
+		samples[i] = sample
+	}
+	return nil
+}
+
+func readFlacFixed(reader *flacBitReader, samples []int64, order int, bitDepth int) error {
+	if order > 4 {
+		return errors.New("invalid FLAC fixed predictor order")
+	}
+	if err := readFlacWarmup(reader, samples, order, bitDepth); err != nil {
+		return err
+	}
+	if err := readFlacResidual(reader, samples, order); err != nil {
+		return err
+	}
+
+	for i := order; i < len(samples); i++ {
+		switch order {
+		case 1:
+			samples[i] += samples[i-1]
+		case 2:
+			samples[i] += 2*samples[i-1] - samples[i-2]
+		case 3:
+			samples[i] += 3*samples[i-1] - 3*samples[i-2] + samples[i-3]
+		case 4:
+			samples[i] += 4*samples[i-1] - 6*samples[i-2] + 4*samples[i-3] - samples[i-4]
+		}
+	}
+	return nil
+}
+
+func readFlacLpc(reader *flacBitReader, samples []int64, order int, bitDepth int) error {
+	if err := readFlacWarmup(reader, samples, order, bitDepth); err != nil {
+		return err
+	}
+
+	precision, err := reader.read(4)
+	if err != nil {
+		return err
+	}
+	if precision == 0xF {
+		return errors.New("invalid FLAC LPC precision")
+	}
+	shift, err := reader.readSigned(5)
+	if err != nil {
+		return err
+	}
+	if shift < 0 {
+		return errors.New("negative FLAC LPC shift")
+	}
+
+	coefficients := make([]int64, order)
+	for i := range coefficients {
+		if coefficients[i], err = reader.readSigned(uint(precision) + 1); err != nil {
+			return err
+		}
+	}
+
+	if err := readFlacResidual(reader, samples, order); err != nil {
+		return err
+	}
+
+	for i := order; i < len(samples); i++ {
+		var prediction int64
+		for j, coefficient := range coefficients {
+			prediction += coefficient * samples[i-1-j]
+		}
+		samples[i] += prediction >> shift
+	}
+	return nil
+}
+
+// Reads the Rice coded prediction errors into samples after the warm up samples.
+func readFlacResidual(reader *flacBitReader, samples []int64, order int) error {
+	method, err := reader.read(2)
+	if err != nil {
+		return err
+	}
+	if method > 1 {
+		return errors.New("invalid FLAC residual coding method")
+	}
+
+	// The second method has wider Rice parameters for high resolution audio.
+	paramWidth, escape := uint(4), uint64(0xF)
+	if method == 1 {
+		paramWidth, escape = 5, 0x1F
+	}
+
+	partitionOrder, err := reader.read(4)
+	if err != nil {
+		return err
+	}
+	partitions := 1 << partitionOrder
+	partitionSize := len(samples) >> partitionOrder
+	if partitionSize < order || partitionSize<<partitionOrder != len(samples) {
+		return errors.New("invalid FLAC residual partition order")
+	}
+
+	i := order
+	for partition := range partitions {
+		end := (partition + 1) * partitionSize
+
+		param, err := reader.read(paramWidth)
+		if err != nil {
+			return err
+		}
+
+		// Escaped partitions store each residual as a plain signed number.
+		if param == escape {
+			width, err := reader.read(5)
+			if err != nil {
+				return err
+			}
+			for ; i < end; i++ {
+				if samples[i], err = reader.readSigned(uint(width)); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		for ; i < end; i++ {
+			high, err := reader.readUnary()
+			if err != nil {
+				return err
+			}
+			low, err := reader.read(uint(param))
+			if err != nil {
+				return err
+			}
+
+			value := high<<param | low
+			samples[i] = int64(value>>1) ^ -int64(value&1)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// Packs values most significant bit first, as FLAC stores them.
+type flacBitWriter struct {
+	data []byte
+	bits int
+}
+
+func (writer *flacBitWriter) write(value int64, width int) {
+	for i := width - 1; i >= 0; i-- {
+		if writer.bits%8 == 0 {
+			writer.data = append(writer.data, 0)
+		}
+		if value>>i&1 != 0 {
+			writer.data[len(writer.data)-1] |= 0x80 >> (writer.bits % 8)
+		}
+		writer.bits++
+	}
+}
+
+func (writer *flacBitWriter) writeRice(value int64, param int) {
+	// Residuals are zigzag coded so small negative values stay small.
+	unsigned := value << 1
+	if value < 0 {
+		unsigned = -value<<1 - 1
+	}
+	for range unsigned >> param {
+		writer.write(0, 1)
+	}
+	writer.write(1, 1)
+	writer.write(unsigned, param)
+}
+
+func (writer *flacBitWriter) align() {
+	writer.bits += (8 - writer.bits%8) % 8
+}
+
+// Writes a frame header with an 8 bit block size and no sample rate or depth override.
+func (writer *flacBitWriter) writeFrameHeader(frame int64, blockSize int, channelAssignment int) {
+	writer.write(0x3FFE, 14)
+	writer.write(0, 2)
+	writer.write(6, 4)
+	writer.write(0, 4)
+	writer.write(int64(channelAssignment), 4)
+	writer.write(0, 4)
+	writer.write(frame, 8)
+	writer.write(int64(blockSize-1), 8)
+	writer.write(0, 8)
+}
+
+func (writer *flacBitWriter) writeFrameFooter() {
+	writer.align()
+	writer.write(0, 16)
+}
+
+// Writes a fixed order 2 subframe using a single Rice partition.
+func (writer *flacBitWriter) writeFixedSubframe(samples []int64, bitDepth int) {
+	writer.write(10<<1, 8)
+	writer.write(samples[0], bitDepth)
+	writer.write(samples[1], bitDepth)
+	writer.write(0, 2)
+	writer.write(0, 4)
+	writer.write(2, 4)
+	for i := 2; i < len(samples); i++ {
+		writer.writeRice(samples[i]-(2*samples[i-1]-samples[i-2]), 2)
+	}
+}
+
+// Writes an order 1 LPC subframe that predicts each sample as the previous one.
+func (writer *flacBitWriter) writeLpcSubframe(samples []int64, bitDepth int) {
+	writer.write(32<<1, 8)
+	writer.write(samples[0], bitDepth)
+	writer.write(14, 4)
+	writer.write(0, 5)
+	writer.write(1, 15)
+	writer.write(1, 2)
+	writer.write(0, 4)
+	writer.write(3, 5)
+	for i := 1; i < len(samples); i++ {
+		writer.writeRice(samples[i]-samples[i-1], 3)
+	}
+}
+
+func (writer *flacBitWriter) writeVerbatimSubframe(samples []int64, bitDepth int) {
+	writer.write(1<<1, 8)
+	for _, sample := range samples {
+		writer.write(sample, bitDepth)
+	}
+}
+
+func (writer *flacBitWriter) writeConstantSubframe(value int64, bitDepth int) {
+	writer.write(0, 8)
+	writer.write(value, bitDepth)
+}
+
+func TestReadFlacPcm(t *testing.T) {
+	left1 := []int64{0, 100, 250, 300, 200, -50, -400, -1000}
+	left2 := []int64{-900, -850, -700, -720, -300, 20, 400, 410}
+	right2 := []int64{5, -5, 15, 25, 35, 10, 0, -32768}
+
+	var writer flacBitWriter
+	writer.data = []byte("fLaC")
+	// A last STREAMINFO block for 16 bit stereo at 44.1 kHz.
+	writer.bits = len(writer.data) * 8
+	writer.write(1<<31|34, 32)
+	writer.write(8<<16|8, 32)
+	writer.write(0, 48)
+	writer.write(44100, 20)
+	writer.write(1, 3)
+	writer.write(15, 5)
+	writer.write(16, 36)
+	writer.write(0, 64)
+	writer.write(0, 64)
+
+	// Independent channels, with a constant right channel.
+	writer.writeFrameHeader(0, len(left1), 1)
+	writer.writeFixedSubframe(left1, 16)
+	writer.writeConstantSubframe(-200, 16)
+	writer.writeFrameFooter()
+
+	// Left and side channels, where the side channel needs an extra bit.
+	side := make([]int64, len(left2))
+	for i := range side {
+		side[i] = left2[i] - right2[i]
+	}
+	writer.writeFrameHeader(1, len(left2), 8)
+	writer.writeLpcSubframe(left2, 16)
+	writer.writeVerbatimSubframe(side, 17)
+	writer.writeFrameFooter()
+
+	filename := filepath.Join(t.TempDir(), "test.flac")
+	if err := os.WriteFile(filename, writer.data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var expected []float64
+	for _, sample := range left1 {
+		expected = append(expected, float64(sample-200)/2)
+	}
+	for i := range left2 {
+		expected = append(expected, float64(left2[i]+right2[i])/2)
+	}
+
+	audio, err := ReadMonoPcm(filename, "FLAC", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if audio.SampleRate != 44100 {
+		t.Errorf("expected a sample rate of 44100, got %d", audio.SampleRate)
+	}
+	if !slices.Equal(audio.Samples, expected) {
+		t.Errorf("expected samples %v, got %v", expected, audio.Samples)
+	}
+
+	// Decoding stops after the requested duration.
+	audio, err = ReadMonoPcm(filename, "FLAC", 10.0/44100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(audio.Samples, expected[:10]) {
+		t.Errorf("expected samples %v, got %v", expected[:10], audio.Samples)
+	}
+}
@@ -0,0 +1,33 @@
+package common
+
+import (
+	"slices"
+	"strings"
+)
+
+// A playlist format registered with the readers or writers package.
+type PlaylistFormat struct {
+	// Type name used with --input-type and --output-type.
+	Name string
+	// Uppercase file extensions the format is inferred from.
+	Extensions  []string
+	Description string
+	// Set for writers that add to an existing file rather than replacing it, so several playlists can share one output.
+	MergesOutput bool
+}
+
+// Returns true if the format has the type name or file extension, ignoring case.
+func (format PlaylistFormat) Matches(typeName string) bool {
+	typeName = strings.ToUpper(typeName)
+	return format.Name == typeName || slices.Contains(format.Extensions, typeName)
+}
+
+// Returns a one line summary of each format, used in help text.
+func DescribeFormats(formats []PlaylistFormat) string {
+	var descriptions []string
+	for _, format := range formats {
+		descriptions = append(descriptions, format.Name+" ("+format.Description+")")
+	}
+
+	return strings.Join(descriptions, ", ")
+}
@@ -0,0 +1,554 @@
+package common
+
+import (
+	"archive/zip"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Library of songs and the indices used to match them. All methods are safe for
+// concurrent use, so a single library can be shared between scanning and matching goroutines.
+type ConverterLibrary struct {
+	// Guards every field below. Unexported, so it is skipped when encoding the db.
+	mu sync.RWMutex
+
+	// Map of internal song id to Song struct.
+	Songs map[int]*Song
+	// Map of Artists to list of songs.
+	ArtistsIndex map[string][]int
+	// Map of Album Artists to list of songs.
+	AlbumArtistsIndex map[string][]int
+	// Map of Albums to list of songs.
+	AlbumsIndex map[string][]int
+	// Map of Titles to list of songs.
+	TitlesIndex map[string][]int
+	// Song ids for indices.
+	Ids map[string]int
+	// Next id for id list.
+	NextId int
+}
+
+func MakeLibrary() *ConverterLibrary {
+	return &ConverterLibrary{
+		Songs:             make(map[int]*Song),
+		ArtistsIndex:      make(map[string][]int),
+		AlbumsIndex:       make(map[string][]int),
+		AlbumArtistsIndex: make(map[string][]int),
+		TitlesIndex:       make(map[string][]int),
+		Ids:               make(map[string]int),
+		NextId:            0,
+	}
+}
+
+// Reads ConverterLibrary from file specified.
+func (lib *ConverterLibrary) TryReadDbFile(file string) {
+	if file == "" {
+		file = ConverterDbFile
+	}
+
+	lib.mu.Lock()
+	defer lib.mu.Unlock()
+
+	if _, err := os.Stat(file); err == nil {
+		// Status goes to stderr so commands writing to stdout (e.g. library export) stay clean.
+		fmt.Fprintln(os.Stderr, "Existing db file found. Reading...")
+		zipR, err := zip.OpenReader(file)
+		if err != nil {
+			panic(err)
+		}
+
+		gobFile, err := zipR.Open(ZippedFilename)
+		if err != nil {
+			panic(err)
+		}
+
+		decoder := gob.NewDecoder(gobFile)
+		decoder.Decode(lib)
+		gobFile.Close()
+		zipR.Close()
+	} else if !errors.Is(err, os.ErrNotExist) {
+		panic(err)
+	}
+}
+
+// Writes ConverterLibrary to file specified.
+func (lib *ConverterLibrary) WriteDbFile(file string) {
+	if file == "" {
+		file = ConverterDbFile
+	}
+
+	lib.mu.RLock()
+	defer lib.mu.RUnlock()
+
+	zipFile, err := os.Create(file)
+	if err != nil {
+		panic(err)
+	}
+
+	zipWriter := zip.NewWriter(zipFile)
+
+	gobFile, err := zipWriter.Create(ZippedFilename)
+	if err != nil {
+		panic(err)
+	}
+	encoder := gob.NewEncoder(gobFile)
+	encoder.Encode(lib)
+
+	zipWriter.Close()
+	zipFile.Close()
+}
+
+// Returns all songs in the library ordered by relpath, then search root.
+func (lib *ConverterLibrary) SortedSongs() []*Song {
+	lib.mu.RLock()
+	songs := slices.Collect(maps.Values(lib.Songs))
+	lib.mu.RUnlock()
+
+	slices.SortFunc(songs, func(a *Song, b *Song) int {
+		if c := strings.Compare(a.Relpath, b.Relpath); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Root, b.Root)
+	})
+
+	return songs
+}
+
+// Returns the number of songs in the library.
+func (lib *ConverterLibrary) Len() int {
+	lib.mu.RLock()
+	defer lib.mu.RUnlock()
+
+	return len(lib.Songs)
+}
+
+// Returns the number of distinct artists, album artists and albums indexed.
+func (lib *ConverterLibrary) IndexSizes() (artists int, albumArtists int, albums int) {
+	lib.mu.RLock()
+	defer lib.mu.RUnlock()
+
+	return len(lib.ArtistsIndex), len(lib.AlbumArtistsIndex), len(lib.AlbumsIndex)
+}
+
+// Returns the library key for a file found under a search root.
+// Keys are namespaced by the full root path so roots sharing a base name do not collide.
+func LibraryKey(root string, relpath string) string {
+	return root + RootSeparatorCharacter + relpath
+}
+
+// Removes all songs whose search root is not in roots, returning the number removed.
+// Songs without a recorded root (from older db files) are always removed so they get rescanned.
+func (lib *ConverterLibrary) PruneRoots(roots []string) int {
+	lib.mu.Lock()
+	defer lib.mu.Unlock()
+
+	removed := make(map[int]bool)
+	for id, song := range lib.Songs {
+		if song.Root == "" || !slices.Contains(roots, song.Root) {
+			removed[id] = true
+		}
+	}
+
+	lib.removeIds(removed)
+
+	return len(removed)
+}
+
+// Adds a song under the given key and indexes its metadata, returning its id.
+// Any song already stored under the key is replaced.
+func (lib *ConverterLibrary) AddSong(key string, song *Song, config *ConverterConfig) int {
+	lib.mu.Lock()
+	defer lib.mu.Unlock()
+
+	if id := lib.getId(key); id != -1 {
+		lib.removeIds(map[int]bool{id: true})
+	}
+
+	id := lib.getNewId(key)
+	lib.Songs[id] = song
+
+	for _, artist := range ArtistSplit(song.Artist, config) {
+		artist = strings.TrimSpace(artist)
+
+		if artist != UnknownArtist {
+			lib.ArtistsIndex[artist] = append(lib.ArtistsIndex[artist], id)
+		}
+	}
+
+	for _, artist := range ArtistSplit(song.AlbumArtist, config) {
+		artist = strings.TrimSpace(artist)
+
+		if artist != UnknownArtist {
+			lib.AlbumArtistsIndex[artist] = append(lib.AlbumArtistsIndex[artist], id)
+		}
+	}
+
+	lib.AlbumsIndex[song.Album] = append(lib.AlbumsIndex[song.Album], id)
+	lib.TitlesIndex[song.Title] = append(lib.TitlesIndex[song.Title], id)
+
+	return id
+}
+
+// Removes the song stored under key, returning false if there was none.
+func (lib *ConverterLibrary) RemoveSong(key string) bool {
+	lib.mu.Lock()
+	defer lib.mu.Unlock()
+
+	id := lib.getId(key)
+	if id == -1 {
+		return false
+	}
+
+	lib.removeIds(map[int]bool{id: true})
+	return true
+}
+
+// Helper function to remove a set of ids from the songs, ids and every index.
+// Callers must hold the write lock.
+func (lib *ConverterLibrary) removeIds(removed map[int]bool) {
+	if len(removed) < 1 {
+		return
+	}
+
+	for id := range removed {
+		delete(lib.Songs, id)
+	}
+
+	for key, id := range lib.Ids {
+		if removed[id] {
+			delete(lib.Ids, key)
+		}
+	}
+
+	for _, index := range []map[string][]int{lib.ArtistsIndex, lib.AlbumArtistsIndex, lib.AlbumsIndex, lib.TitlesIndex} {
+		pruneIndex(index, removed)
+	}
+}
+
+// Helper function to drop removed ids from an index, deleting entries left empty.
+func pruneIndex(index map[string][]int, removed map[int]bool) {
+	for key, ids := range index {
+		ids = slices.DeleteFunc(ids, func(id int) bool { return removed[id] })
+		if len(ids) < 1 {
+			delete(index, key)
+		} else {
+			index[key] = ids
+		}
+	}
+}
+
+// Returns id of a song, otherwise returns -1.
+func (lib *ConverterLibrary) GetId(path string) int {
+	lib.mu.RLock()
+	defer lib.mu.RUnlock()
+
+	return lib.getId(path)
+}
+
+func (lib *ConverterLibrary) getId(path string) int {
+	if id, exists := lib.Ids[path]; exists {
+		return id
+	} else {
+		return -1
+	}
+}
+
+// Returns new id for a new song.
+func (lib *ConverterLibrary) GetNewId(path string) int {
+	lib.mu.Lock()
+	defer lib.mu.Unlock()
+
+	return lib.getNewId(path)
+}
+
+func (lib *ConverterLibrary) getNewId(path string) int {
+	id := lib.NextId
+	lib.Ids[path] = id
+
+	lib.NextId += 1
+
+	return id
+}
+
+// Helper function to return a list of possible matches. Callers must hold the read lock.
+func (lib *ConverterLibrary) getMatchCandidates(formatStr string, config *ConverterConfig) (map[int]float32, map[int]bool) {
+	// Use a map in place of a set (to avoid dupes).
+	candidateMap := make(map[int]float32)
+	// Candidates whose title matched, or every candidate if the format has no title.
+	titleMatches := make(map[int]bool)
+	hasTitle := false
+	splitFormatStr := strings.Split(formatStr, FormatSeparatorCharacter)
+
+	for i, split := range strings.Split(config.Format, FormatSeparatorCharacter) {
+		if split == ArtistFormat {
+			// Special case for artists, since there may be multiple.
+			for _, splitArtist := range ArtistSplit(splitFormatStr[i], config) {
+				trimmedArtist := strings.TrimSpace(splitArtist)
+				for _, candidate := range lib.ArtistsIndex[trimmedArtist] {
+					if val, present := candidateMap[candidate]; present {
+						candidateMap[candidate] = val + ArtistMatchVal
+					} else {
+						candidateMap[candidate] = ArtistMatchVal
+					}
+				}
+			}
+		} else if split == AlbumArtistFormat {
+			// Again, special case for artists, since there may be multiple.
+			for _, splitArtist := range ArtistSplit(splitFormatStr[i], config) {
+				for _, candidate := range lib.AlbumArtistsIndex[strings.TrimSpace(splitArtist)] {
+					if val, present := candidateMap[candidate]; present {
+						candidateMap[candidate] = val + AlbumArtistMatchVal
+					} else {
+						candidateMap[candidate] = AlbumArtistMatchVal
+					}
+				}
+			}
+		} else if split == AlbumFormat {
+			for _, candidate := range lib.AlbumsIndex[splitFormatStr[i]] {
+				if val, present := candidateMap[candidate]; present {
+					candidateMap[candidate] = val + AlbumMatchVal
+				} else {
+					candidateMap[candidate] = AlbumMatchVal
+				}
+			}
+		} else if split == TitleFormat {
+			hasTitle = true
+			for _, candidate := range lib.TitlesIndex[splitFormatStr[i]] {
+				titleMatches[candidate] = true
+				if val, present := candidateMap[candidate]; present {
+					candidateMap[candidate] = val + TitleMatchVal
+				} else {
+					candidateMap[candidate] = TitleMatchVal
+				}
+			}
+		}
+	}
+
+	if !hasTitle {
+		for candidate := range candidateMap {
+			titleMatches[candidate] = true
+		}
+	}
+
+	return candidateMap, titleMatches
+}
+
+// A possible match for a format string along with its match score.
+type MatchCandidate struct {
+	Song  *Song
+	Score float32
+}
+
+// Returns every candidate for a format string ordered from best to worst match, ignoring
+// the minimum match allowance.
+func (lib *ConverterLibrary) GetRankedCandidates(formatStr string, config *ConverterConfig) []MatchCandidate {
+	lib.mu.RLock()
+	defer lib.mu.RUnlock()
+
+	candidates, titleMatches := lib.getMatchCandidates(formatStr, config)
+
+	ranked := make([]MatchCandidate, 0, len(candidates))
+	ids := make(map[*Song]int, len(candidates))
+	for candidate, val := range candidates {
+		// Add any additional values based on the candidate (this can positively bias
+		// a specific version of a file in the case of dupes). Bonuses are larger than a title match,
+		// so they only apply once the title matches, or they would match other songs on the same album.
+		if titleMatches[candidate] {
+			val += config.FiletypeBonuses[lib.Songs[candidate].GetFiletype()]
+		}
+
+		ranked = append(ranked, MatchCandidate{Song: lib.Songs[candidate], Score: val})
+		ids[lib.Songs[candidate]] = candidate
+	}
+
+	// Break ties with the quality preference policy, then on id so results are stable between runs.
+	slices.SortFunc(ranked, func(a MatchCandidate, b MatchCandidate) int {
+		if a.Score != b.Score {
+			if a.Score > b.Score {
+				return -1
+			}
+			return 1
+		}
+		if c := ComparePreference(a.Song, b.Song, config.QualityPreference); c != 0 {
+			return c
+		}
+		return ids[a.Song] - ids[b.Song]
+	})
+
+	return ranked
+}
+
+// Function to get a Song ptr based on format string and ConverterConfig allowances.
+func (lib *ConverterLibrary) GetSongFromFormatString(formatStr string, config *ConverterConfig) *Song {
+	ranked := lib.GetRankedCandidates(formatStr, config)
+
+	if len(ranked) > 0 && ranked[0].Score > config.MinimumMatchAllowance {
+		return ranked[0].Song
+	} else {
+		return nil
+	}
+}
+
+// Returns the song whose fingerprint is most similar to the given one, if any reaches the
+// fingerprint match allowance, along with its similarity. This is a linear scan of the library.
+func (lib *ConverterLibrary) GetSongFromFingerprint(fingerprint []uint32, config *ConverterConfig) (*Song, float32) {
+	lib.mu.RLock()
+	defer lib.mu.RUnlock()
+
+	var best *Song
+	var bestSimilarity float32
+	for _, id := range slices.Sorted(maps.Keys(lib.Songs)) {
+		song := lib.Songs[id]
+		if len(song.Fingerprint) < 1 {
+			continue
+		}
+
+		if similarity := FingerprintSimilarity(fingerprint, song.Fingerprint); similarity > bestSimilarity {
+			best = song
+			bestSimilarity = similarity
+		}
+	}
+
+	if bestSimilarity < config.FingerprintAllowance {
+		return nil, bestSimilarity
+	}
+
+	return best, bestSimilarity
+}
+
+// Returns groups of fingerprinted songs that are similar enough to be the same recording,
+// each sorted by preference. Every pair of songs is compared, so this is slow on large libraries.
+func (lib *ConverterLibrary) GetFingerprintDuplicateGroups(config *ConverterConfig) [][]*Song {
+	lib.mu.RLock()
+	defer lib.mu.RUnlock()
+
+	var songs []*Song
+	for _, id := range slices.Sorted(maps.Keys(lib.Songs)) {
+		if len(lib.Songs[id].Fingerprint) > 0 {
+			songs = append(songs, lib.Songs[id])
+		}
+	}
+
+	// Union find over similar pairs.
+	parents := make([]int, len(songs))
+	for i := range parents {
+		parents[i] = i
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		if parents[i] != i {
+			parents[i] = find(parents[i])
+		}
+		return parents[i]
+	}
+
+	for i := range songs {
+		for j := i + 1; j < len(songs); j++ {
+			if FingerprintSimilarity(songs[i].Fingerprint, songs[j].Fingerprint) >= config.FingerprintAllowance {
+				parents[find(j)] = find(i)
+			}
+		}
+	}
+
+	grouped := make(map[int][]*Song)
+	for i, song := range songs {
+		grouped[find(i)] = append(grouped[find(i)], song)
+	}
+
+	var groups [][]*Song
+	for _, group := range grouped {
+		if len(group) > 1 {
+			SortByPreference(group, config)
+			groups = append(groups, group)
+		}
+	}
+
+	slices.SortFunc(groups, func(a []*Song, b []*Song) int {
+		return strings.Compare(a[0].Relpath, b[0].Relpath)
+	})
+
+	return groups
+}
+
+// Orders songs with identical metadata the way the matcher would choose between them:
+// by filetype bonus, then by the quality preference policy.
+func SortByPreference(songs []*Song, config *ConverterConfig) {
+	slices.SortStableFunc(songs, func(a *Song, b *Song) int {
+		aBonus := config.FiletypeBonuses[a.GetFiletype()]
+		bBonus := config.FiletypeBonuses[b.GetFiletype()]
+		if aBonus != bBonus {
+			if aBonus > bBonus {
+				return -1
+			}
+			return 1
+		}
+		return ComparePreference(a, b, config.QualityPreference)
+	})
+}
+
+// Returns groups of songs sharing the same artist, album and title, each sorted by preference.
+// If durationTolerance is positive, songs are only grouped if their durations are within it.
+func (lib *ConverterLibrary) GetDuplicateGroups(durationTolerance time.Duration, config *ConverterConfig) [][]*Song {
+	lib.mu.RLock()
+	defer lib.mu.RUnlock()
+
+	var groups [][]*Song
+	for _, ids := range lib.TitlesIndex {
+		if len(ids) < 2 {
+			continue
+		}
+
+		// Songs sharing a title are split further by artist and album.
+		byMetadata := make(map[string][]*Song)
+		for _, id := range slices.Sorted(slices.Values(ids)) {
+			song := lib.Songs[id]
+			key := song.Artist + FormatSeparatorCharacter + song.Album
+			byMetadata[key] = append(byMetadata[key], song)
+		}
+
+		for _, songs := range byMetadata {
+			for _, group := range splitByDuration(songs, durationTolerance) {
+				if len(group) > 1 {
+					SortByPreference(group, config)
+					groups = append(groups, group)
+				}
+			}
+		}
+	}
+
+	slices.SortFunc(groups, func(a []*Song, b []*Song) int {
+		return strings.Compare(a[0].Relpath, b[0].Relpath)
+	})
+
+	return groups
+}
+
+// Helper function to split songs into runs whose neighbouring durations are within tolerance.
+func splitByDuration(songs []*Song, tolerance time.Duration) [][]*Song {
+	if tolerance <= 0 {
+		return [][]*Song{songs}
+	}
+
+	sorted := slices.Clone(songs)
+	slices.SortStableFunc(sorted, func(a *Song, b *Song) int {
+		return int(a.Duration - b.Duration)
+	})
+
+	var groups [][]*Song
+	start := 0
+	for i := 1; i <= len(sorted); i++ {
+		if i == len(sorted) || sorted[i].Duration-sorted[i-1].Duration > tolerance {
+			groups = append(groups, sorted[start:i])
+			start = i
+		}
+	}
+
+	return groups
+}
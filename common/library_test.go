@@ -0,0 +1,248 @@
+package common
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+)
+
+func makeTestSong(root string, artist string, album string, title string) *Song {
+	return &Song{
+		Filepath:    root + "/" + title + ".flac",
+		Relpath:     "Music/" + title + ".flac",
+		Root:        root,
+		Artist:      artist,
+		AlbumArtist: artist,
+		Album:       album,
+		Title:       title,
+		TrackNumber: -1,
+		Filetype:    "FLAC",
+	}
+}
+
+func testFormatString(artist string, album string, title string) string {
+	return artist + FormatSeparatorCharacter + album + FormatSeparatorCharacter + title
+}
+
+// Checks that ids, songs and every index agree with each other.
+func checkLibraryConsistency(t *testing.T, lib *ConverterLibrary) {
+	t.Helper()
+
+	if len(lib.Ids) != len(lib.Songs) {
+		t.Errorf("library has %d ids but %d songs", len(lib.Ids), len(lib.Songs))
+	}
+
+	for key, id := range lib.Ids {
+		if _, exists := lib.Songs[id]; !exists {
+			t.Errorf("id %d for key %q has no song", id, key)
+		}
+	}
+
+	indices := map[string]map[string][]int{
+		"artists":       lib.ArtistsIndex,
+		"album artists": lib.AlbumArtistsIndex,
+		"albums":        lib.AlbumsIndex,
+		"titles":        lib.TitlesIndex,
+	}
+	for name, index := range indices {
+		for key, ids := range index {
+			if len(ids) < 1 {
+				t.Errorf("%s index has an empty entry for %q", name, key)
+			}
+			for _, id := range ids {
+				if _, exists := lib.Songs[id]; !exists {
+					t.Errorf("%s index entry %q refers to removed song %d", name, key, id)
+				}
+			}
+		}
+	}
+
+	for id, song := range lib.Songs {
+		if !slices.Contains(lib.AlbumsIndex[song.Album], id) {
+			t.Errorf("song %d is missing from the albums index", id)
+		}
+		if !slices.Contains(lib.TitlesIndex[song.Title], id) {
+			t.Errorf("song %d is missing from the titles index", id)
+		}
+		if !slices.Contains(lib.ArtistsIndex[song.Artist], id) {
+			t.Errorf("song %d is missing from the artists index", id)
+		}
+	}
+}
+
+func TestLibraryRemoveSong(t *testing.T) {
+	config := MakeConverterConfig()
+	lib := MakeLibrary()
+
+	lib.AddSong(LibraryKey("/a", "one.flac"), makeTestSong("/a", "Artist", "Album", "One"), &config)
+	lib.AddSong(LibraryKey("/a", "two.flac"), makeTestSong("/a", "Artist", "Album", "Two"), &config)
+
+	if !lib.RemoveSong(LibraryKey("/a", "one.flac")) {
+		t.Fatal("RemoveSong returned false for an existing song")
+	}
+	if lib.RemoveSong(LibraryKey("/a", "one.flac")) {
+		t.Error("RemoveSong returned true for a song already removed")
+	}
+
+	if lib.Len() != 1 {
+		t.Errorf("expected 1 song after remove, got %d", lib.Len())
+	}
+	if _, exists := lib.TitlesIndex["One"]; exists {
+		t.Error("removed song's title is still indexed")
+	}
+	if song := lib.GetSongFromFormatString(testFormatString("Artist", "Album", "One"), &config); song != nil && song.Title == "One" {
+		t.Error("removed song is still matched")
+	}
+	if song := lib.GetSongFromFormatString(testFormatString("Artist", "Album", "Two"), &config); song == nil || song.Title != "Two" {
+		t.Errorf("expected remaining song to match, got %v", song)
+	}
+
+	checkLibraryConsistency(t, lib)
+}
+
+func TestLibraryAddSongReplacesKey(t *testing.T) {
+	config := MakeConverterConfig()
+	lib := MakeLibrary()
+
+	key := LibraryKey("/a", "song.flac")
+	lib.AddSong(key, makeTestSong("/a", "Artist", "Album", "Old"), &config)
+	lib.AddSong(key, makeTestSong("/a", "Artist", "Album", "New"), &config)
+
+	if lib.Len() != 1 {
+		t.Errorf("expected re-adding a key to replace its song, got %d songs", lib.Len())
+	}
+	if _, exists := lib.TitlesIndex["Old"]; exists {
+		t.Error("replaced song's title is still indexed")
+	}
+
+	checkLibraryConsistency(t, lib)
+}
+
+func TestLibraryPruneRoots(t *testing.T) {
+	config := MakeConverterConfig()
+	lib := MakeLibrary()
+
+	// Two roots with the same base name produce identical relpaths, but must stay separate songs.
+	lib.AddSong(LibraryKey("/mnt/a/Music", "song.flac"), makeTestSong("/mnt/a/Music", "Artist", "Album", "Kept"), &config)
+	lib.AddSong(LibraryKey("/mnt/b/Music", "song.flac"), makeTestSong("/mnt/b/Music", "Other", "Other Album", "Pruned"), &config)
+
+	if pruned := lib.PruneRoots([]string{"/mnt/a/Music"}); pruned != 1 {
+		t.Errorf("expected 1 song pruned, got %d", pruned)
+	}
+
+	if lib.Len() != 1 {
+		t.Errorf("expected 1 song after prune, got %d", lib.Len())
+	}
+	if _, exists := lib.ArtistsIndex["Other"]; exists {
+		t.Error("pruned song's artist is still indexed")
+	}
+	if lib.GetId(LibraryKey("/mnt/b/Music", "song.flac")) != -1 {
+		t.Error("pruned song's key still has an id")
+	}
+	if lib.GetId(LibraryKey("/mnt/a/Music", "song.flac")) == -1 {
+		t.Error("song under a kept root was pruned")
+	}
+
+	checkLibraryConsistency(t, lib)
+}
+
+func TestFiletypeBonusNeedsTitleMatch(t *testing.T) {
+	config := MakeConverterConfig()
+	lib := MakeLibrary()
+
+	for _, filetype := range []string{"WAV", "FLAC", "APE"} {
+		song := makeTestSong("/a", "Artist A", "Album X", "Song One")
+		song.Filepath = "/a/01 - Song One." + filetype
+		song.Filetype = filetype
+		lib.AddSong(LibraryKey("/a", filetype), song, &config)
+	}
+
+	// Artist and album alone score 0.8, which a bonus must not push past the match allowance.
+	if song := lib.GetSongFromFormatString(testFormatString("Artist A", "Album X", "Missing Song"), &config); song != nil {
+		t.Errorf("expected no match without the title, got %s", song.Filepath)
+	}
+
+	if song := lib.GetSongFromFormatString(testFormatString("Artist A", "Album X", "Song One"), &config); song == nil || song.Filetype != "FLAC" && song.Filetype != "APE" {
+		t.Errorf("expected a lossless compressed version to be preferred, got %v", song)
+	}
+}
+
+// Run with -race to check the library's locking.
+func TestLibraryConcurrentAccess(t *testing.T) {
+	config := MakeConverterConfig()
+	lib := MakeLibrary()
+	roots := []string{"/a", "/b"}
+
+	const songsPerWorker = 200
+	var wg sync.WaitGroup
+
+	// Writers add songs under both roots, and remove every third one again.
+	for worker := range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range songsPerWorker {
+				root := roots[i%len(roots)]
+				title := fmt.Sprintf("Song %d-%d", worker, i)
+				key := LibraryKey(root, title+".flac")
+				lib.AddSong(key, makeTestSong(root, fmt.Sprintf("Artist %d", i%10), "Album", title), &config)
+
+				if i%3 == 0 {
+					lib.RemoveSong(key)
+				}
+			}
+		}()
+	}
+
+	// Pruning with every root configured must not remove anything, even while songs are added.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for range 50 {
+			if pruned := lib.PruneRoots(roots); pruned != 0 {
+				t.Errorf("pruned %d songs under configured roots", pruned)
+			}
+		}
+	}()
+
+	// Readers match against the library while it changes.
+	for worker := range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range songsPerWorker {
+				formatStr := testFormatString(fmt.Sprintf("Artist %d", i%10), "Album", fmt.Sprintf("Song %d-%d", worker, i))
+				lib.GetSongFromFormatString(formatStr, &config)
+				lib.GetRankedCandidates(formatStr, &config)
+				lib.IndexSizes()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	removed := (songsPerWorker + 2) / 3
+	if expected := 4 * (songsPerWorker - removed); lib.Len() != expected {
+		t.Errorf("expected %d songs, got %d", expected, lib.Len())
+	}
+	checkLibraryConsistency(t, lib)
+
+	// Every remaining song should still be matched exactly.
+	for _, song := range lib.SortedSongs() {
+		if match := lib.GetSongFromFormatString(testFormatString(song.Artist, song.Album, song.Title), &config); match != song {
+			t.Errorf("expected %q to match itself, got %v", song.Title, match)
+		}
+	}
+
+	before := lib.Len()
+	if pruned := lib.PruneRoots([]string{"/a"}); pruned < 1 || lib.Len() != before-pruned {
+		t.Errorf("pruning /b removed %d of %d songs, leaving %d", pruned, before, lib.Len())
+	}
+	for _, song := range lib.SortedSongs() {
+		if song.Root != "/a" {
+			t.Errorf("song under pruned root %s remains", song.Root)
+		}
+	}
+	checkLibraryConsistency(t, lib)
+}
@@ -0,0 +1,74 @@
+package common
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+)
+
+// Boxes that need to be descended into to reach the sample description.
+var mp4ContainerBoxes = []string{"moov", "trak", "mdia", "minf", "stbl"}
+
+// Returns the codec of the first audio sample description in an MP4 file, e.g. "mp4a" or "alac".
+func Mp4AudioCodec(filename string) (string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	return findMp4Codec(file, 0, info.Size(), 0)
+}
+
+// Walks the boxes between start and end looking for the stsd box, following mp4ContainerBoxes down in order.
+func findMp4Codec(file *os.File, start int64, end int64, depth int) (string, error) {
+	header := make([]byte, 16)
+	for offset := start; offset+8 <= end; {
+		if _, err := file.ReadAt(header[:8], offset); err != nil {
+			return "", err
+		}
+
+		size := int64(binary.BigEndian.Uint32(header[:4]))
+		boxType := string(header[4:8])
+		headerSize := int64(8)
+
+		if size == 1 {
+			if _, err := file.ReadAt(header[8:16], offset+8); err != nil {
+				return "", err
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:16]))
+			headerSize = 16
+		} else if size == 0 {
+			size = end - offset
+		}
+
+		if size < headerSize || offset+size > end {
+			return "", errors.New("malformed mp4 box " + boxType)
+		}
+
+		if depth < len(mp4ContainerBoxes) && boxType == mp4ContainerBoxes[depth] {
+			codec, err := findMp4Codec(file, offset+headerSize, offset+size, depth+1)
+			// A file may hold several tracks, so keep looking if this one had no audio.
+			if err == nil || boxType != "trak" {
+				return codec, err
+			}
+		} else if depth == len(mp4ContainerBoxes) && boxType == "stsd" {
+			// Full box header (4 bytes), entry count (4 bytes), then the first entry's size and format.
+			entry := make([]byte, 8)
+			if _, err := file.ReadAt(entry, offset+headerSize+8); err != nil {
+				return "", err
+			}
+			return string(entry[4:8]), nil
+		}
+
+		offset += size
+	}
+
+	return "", io.EOF
+}
@@ -0,0 +1,234 @@
+package common
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"os"
+)
+
+// Decoded mono audio, used for fingerprinting.
+type MonoAudio struct {
+	Samples    []float64
+	SampleRate int
+}
+
+// Reads up to maxDuration seconds of a PCM WAV, AIFF or FLAC file, mixed down to mono.
+// Lossy formats cannot be decoded without external libraries, so they return an error.
+func ReadMonoPcm(filename string, filetype string, maxDuration float64) (MonoAudio, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return MonoAudio{}, err
+	}
+	defer file.Close()
+
+	switch filetype {
+	case "WAV":
+		return readWavPcm(file, maxDuration)
+	case "AIF", "AIFF", "AIFC":
+		return readAiffPcm(file, maxDuration)
+	case "FLAC":
+		return readFlacPcm(file, maxDuration)
+	default:
+		return MonoAudio{}, errors.New("cannot decode audio for " + filetype)
+	}
+}
+
+// Layout of the interleaved PCM samples in a file.
+type pcmFormat struct {
+	channels   int
+	sampleRate int
+	bitDepth   int
+	float      bool
+	bigEndian  bool
+}
+
+func readWavPcm(file *os.File, maxDuration float64) (MonoAudio, error) {
+	chunks, err := readWavChunks(file)
+	if err != nil {
+		return MonoAudio{}, err
+	}
+
+	var format pcmFormat
+	for {
+		id, size, err := chunks.nextChunk()
+		if err != nil {
+			return MonoAudio{}, err
+		}
+
+		switch id {
+		case "fmt ":
+			if size < 16 {
+				return MonoAudio{}, errors.New("WAVE fmt chunk too short")
+			}
+			fmtChunk, err := chunks.read(size)
+			if err != nil {
+				return MonoAudio{}, err
+			}
+
+			tag := binary.LittleEndian.Uint16(fmtChunk[0:2])
+			// WAVE_FORMAT_EXTENSIBLE keeps the real format tag at the start of its subformat GUID.
+			if tag == 0xFFFE && size >= 26 {
+				tag = binary.LittleEndian.Uint16(fmtChunk[24:26])
+			}
+			if tag != 1 && tag != 3 {
+				return MonoAudio{}, errors.New("WAVE file is not PCM")
+			}
+
+			format = pcmFormat{
+				channels:   int(binary.LittleEndian.Uint16(fmtChunk[2:4])),
+				sampleRate: int(binary.LittleEndian.Uint32(fmtChunk[4:8])),
+				bitDepth:   int(binary.LittleEndian.Uint16(fmtChunk[14:16])),
+				float:      tag == 3,
+			}
+		case "data":
+			if format.channels == 0 {
+				return MonoAudio{}, errors.New("WAVE data chunk before fmt chunk")
+			}
+			return readPcmSamples(file, size, format, maxDuration)
+		}
+	}
+}
+
+func readAiffPcm(file *os.File, maxDuration float64) (MonoAudio, error) {
+	chunks, err := readAiffChunks(file)
+	if err != nil {
+		return MonoAudio{}, err
+	}
+
+	var format pcmFormat
+	for {
+		id, size, err := chunks.nextChunk()
+		if err != nil {
+			return MonoAudio{}, err
+		}
+
+		switch id {
+		case "COMM":
+			if size < 18 {
+				return MonoAudio{}, errors.New("AIFF COMM chunk too short")
+			}
+			comm, err := chunks.read(size)
+			if err != nil {
+				return MonoAudio{}, err
+			}
+
+			format = pcmFormat{
+				channels:   int(binary.BigEndian.Uint16(comm[0:2])),
+				bitDepth:   int(binary.BigEndian.Uint16(comm[6:8])),
+				sampleRate: int(extendedToFloat(comm[8:18])),
+				bigEndian:  true,
+			}
+
+			// AIFC files name their compression, of which only uncompressed PCM is supported.
+			if chunks.form == "AIFC" && size >= 22 {
+				switch string(comm[18:22]) {
+				case "NONE", "twos":
+				case "sowt":
+					format.bigEndian = false
+				case "fl32", "FL32":
+					format.float = true
+				default:
+					return MonoAudio{}, errors.New("compressed AIFC files are not supported")
+				}
+			}
+		case "SSND":
+			if format.channels == 0 {
+				return MonoAudio{}, errors.New("AIFF SSND chunk before COMM chunk")
+			}
+
+			// Offset and block size precede the sample data.
+			ssnd, err := chunks.read(8)
+			if err != nil {
+				return MonoAudio{}, err
+			}
+			offset := int64(binary.BigEndian.Uint32(ssnd[0:4]))
+			if _, err := file.Seek(offset, io.SeekCurrent); err != nil {
+				return MonoAudio{}, err
+			}
+			return readPcmSamples(file, size-8-offset, format, maxDuration)
+		}
+	}
+}
+
+// Converts an 80-bit IEEE 754 extended precision number, as used for AIFF sample rates.
+func extendedToFloat(b []byte) float64 {
+	exponent := int(binary.BigEndian.Uint16(b[0:2]) & 0x7FFF)
+	mantissa := binary.BigEndian.Uint64(b[2:10])
+	if exponent == 0 && mantissa == 0 {
+		return 0
+	}
+
+	value := float64(mantissa) * math.Pow(2, float64(exponent-16383-63))
+	if b[0]&0x80 != 0 {
+		value = -value
+	}
+	return value
+}
+
+// Reads interleaved samples from the current position, averaging channels into a mono signal.
+func readPcmSamples(reader io.Reader, size int64, format pcmFormat, maxDuration float64) (MonoAudio, error) {
+	bytesPerSample := format.bitDepth / 8
+	if bytesPerSample < 1 || bytesPerSample > 4 || format.channels < 1 || format.sampleRate < 1 {
+		return MonoAudio{}, errors.New("unsupported PCM format")
+	}
+
+	frameSize := int64(bytesPerSample * format.channels)
+	frames := size / frameSize
+	if maxDuration > 0 {
+		frames = min(frames, int64(maxDuration*float64(format.sampleRate)))
+	}
+
+	data := make([]byte, frames*frameSize)
+	n, err := io.ReadFull(reader, data)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return MonoAudio{}, err
+	}
+	frames = int64(n) / frameSize
+
+	samples := make([]float64, frames)
+	for i := range samples {
+		var sum float64
+		for c := range format.channels {
+			offset := (int64(i)*int64(format.channels) + int64(c)) * int64(bytesPerSample)
+			sum += decodePcmSample(data[offset:offset+int64(bytesPerSample)], format)
+		}
+		samples[i] = sum / float64(format.channels)
+	}
+
+	return MonoAudio{Samples: samples, SampleRate: format.sampleRate}, nil
+}
+
+// Decodes a single sample scaled to the range of a 16 bit integer.
+func decodePcmSample(b []byte, format pcmFormat) float64 {
+	var order binary.ByteOrder = binary.LittleEndian
+	if format.bigEndian {
+		order = binary.BigEndian
+	}
+
+	if format.float && len(b) == 4 {
+		return float64(math.Float32frombits(order.Uint32(b))) * 32768
+	}
+
+	switch len(b) {
+	case 1:
+		// 8 bit WAV is unsigned, while 8 bit AIFF is signed.
+		if format.bigEndian {
+			return float64(int8(b[0])) * 256
+		}
+		return (float64(b[0]) - 128) * 256
+	case 2:
+		return float64(int16(order.Uint16(b)))
+	case 3:
+		var v int32
+		if format.bigEndian {
+			v = int32(b[0])<<24 | int32(b[1])<<16 | int32(b[2])<<8
+		} else {
+			v = int32(b[2])<<24 | int32(b[1])<<16 | int32(b[0])<<8
+		}
+		return float64(v) / 65536
+	default:
+		return float64(int32(order.Uint32(b))) / 65536
+	}
+}
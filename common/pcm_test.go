@@ -0,0 +1,93 @@
+package common
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// Appends a chunk with its padding byte, if it has an odd size.
+func appendChunk(data []byte, order binary.AppendByteOrder, id string, contents []byte) []byte {
+	data = append(data, id...)
+	data = order.AppendUint32(data, uint32(len(contents)))
+	data = append(data, contents...)
+	if len(contents)%2 == 1 {
+		data = append(data, 0)
+	}
+	return data
+}
+
+func writeAudioFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+
+	filename := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(filename, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return filename
+}
+
+func TestReadWavPcm(t *testing.T) {
+	fmtChunk := binary.LittleEndian.AppendUint16(nil, 1)
+	fmtChunk = binary.LittleEndian.AppendUint16(fmtChunk, 2)
+	fmtChunk = binary.LittleEndian.AppendUint32(fmtChunk, 8000)
+	fmtChunk = binary.LittleEndian.AppendUint32(fmtChunk, 8000*4)
+	fmtChunk = binary.LittleEndian.AppendUint16(fmtChunk, 4)
+	fmtChunk = binary.LittleEndian.AppendUint16(fmtChunk, 16)
+
+	var samples []byte
+	for _, sample := range []int16{100, 300, -200, -400} {
+		samples = binary.LittleEndian.AppendUint16(samples, uint16(sample))
+	}
+
+	// An odd sized chunk before fmt must be skipped along with its padding.
+	data := []byte("RIFF\x00\x00\x00\x00WAVE")
+	data = appendChunk(data, binary.LittleEndian, "LIST", []byte("odd"))
+	data = appendChunk(data, binary.LittleEndian, "fmt ", fmtChunk)
+	data = appendChunk(data, binary.LittleEndian, "data", samples)
+	filename := writeAudioFile(t, "test.wav", data)
+
+	audio, err := ReadMonoPcm(filename, "WAV", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []float64{200, -300}; audio.SampleRate != 8000 || !slices.Equal(audio.Samples, expected) {
+		t.Errorf("expected samples %v at 8000 Hz, got %v at %d Hz", expected, audio.Samples, audio.SampleRate)
+	}
+
+	if bits, err := ReadBitDepth(filename, "WAV"); err != nil || bits != 16 {
+		t.Errorf("expected a bit depth of 16, got %d (%v)", bits, err)
+	}
+}
+
+func TestReadAiffPcm(t *testing.T) {
+	comm := binary.BigEndian.AppendUint16(nil, 1)
+	comm = binary.BigEndian.AppendUint32(comm, 3)
+	comm = binary.BigEndian.AppendUint16(comm, 8)
+	// 8000 as an 80-bit extended float.
+	comm = append(comm, 0x40, 0x0B, 0xFA, 0, 0, 0, 0, 0, 0, 0)
+
+	ssnd := make([]byte, 8)
+	ssnd = append(ssnd, 0x40, 0xC0, 0x00)
+
+	data := []byte("FORM\x00\x00\x00\x00AIFF")
+	data = appendChunk(data, binary.BigEndian, "NAME", []byte("odd"))
+	data = appendChunk(data, binary.BigEndian, "COMM", comm)
+	data = appendChunk(data, binary.BigEndian, "SSND", ssnd)
+	filename := writeAudioFile(t, "test.aiff", data)
+
+	audio, err := ReadMonoPcm(filename, "AIFF", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []float64{16384, -16384, 0}; audio.SampleRate != 8000 || !slices.Equal(audio.Samples, expected) {
+		t.Errorf("expected samples %v at 8000 Hz, got %v at %d Hz", expected, audio.Samples, audio.SampleRate)
+	}
+
+	if bits, err := ReadBitDepth(filename, "AIFF"); err != nil || bits != 8 {
+		t.Errorf("expected a bit depth of 8, got %d (%v)", bits, err)
+	}
+}
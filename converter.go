@@ -3,13 +3,9 @@ package main
 import (
 	"errors"
 	"fmt"
-	"io/fs"
-	"log"
 	"os"
+	"path"
 	"path/filepath"
-	"runtime"
-	"slices"
-	"strconv"
 	"strings"
 
 	common "dstet.me/p2m3u/common"
@@ -17,36 +13,53 @@ import (
 	writers "dstet.me/p2m3u/writers"
 	"github.com/alecthomas/kong"
 	"github.com/pelletier/go-toml/v2"
-	"go.senan.xyz/taglib"
 )
 
-var WhitelistedFiletypes = []string{
-	"OGG",
-	"MP3",
-	"M4A",
-	"FLAC",
-	"WAV",
-	"AIFF",
+// Flags shared by every command.
+type Globals struct {
+	Config string `short:"c" help:"Config file to use" type:"path"`
+	DbFile string `help:"Custom db file" type:"path" optional:""`
 }
 
-var InputTypes = []string{
-	"CSV",
-	"EXPORTIFY",
+// Flags controlling how output playlists are written, shared by every converting command.
+type OutputFlags struct {
+	PathStyle    string            `help:"Path separators to write: native, windows or posix" enum:"${path_styles}" default:"native"`
+	PathMode     string            `help:"Base of written paths: library (relative to the search root's parent), relative (to the output file), absolute or prefix" enum:"${path_modes}" default:"library"`
+	PathPrefix   map[string]string `help:"Absolute path prefix to rewrite in prefix mode, e.g. Z:/Music=/mnt/music. Overrides the config's PathPrefixes" optional:""`
+	PlaylistName string            `help:"Playlist name written by formats that support one. {Name} is replaced with the input's file name" optional:""`
+	Missing      string            `help:"How unmatched entries are written: drop, comment (# MISSING: Artist - Album - Title) or placeholder" enum:"${missing_modes}" default:"drop"`
+	Placeholder  string            `help:"Path written for unmatched entries with --missing placeholder" default:"MISSING"`
 }
 
-var OutputTypes = []string{
-	"M3U",
+func (flags OutputFlags) pathOptions() writers.PathOptions {
+	return writers.PathOptions{Style: flags.PathStyle, Mode: flags.PathMode, Prefixes: flags.PathPrefix}
 }
 
-var CLI struct {
+type ConvertCmd struct {
 	Input         string   `arg:"" help:"Input playlist" type:"path"`
 	Output        string   `arg:"" help:"Output file" type:"path"`
-	SearchDirs    []string `arg:"" help:"Directories to search" type:"path" optional:""`
-	Config        string   `short:"c" help:"Config file to use" type:"path"`
-	DbFile        string   `help:"Custom db file" type:"path" optional:""`
-	OutputMissing string   `help:"File to output missing songs" type:"path" optional:""`
-	InputType     string   `short:"i" help:"Mode to parse input file" optional:""`
-	OutputType    string   `short:"o" help:"Mode to write output file" optional:""`
+	SearchDirs    []string `arg:"" help:"Directories to scan before converting" type:"path" optional:""`
+	Scan          bool     `help:"Rescan configured search paths before converting"`
+	OutputMissing string   `help:"File to write a report of missing songs to" type:"path" optional:""`
+	MissingFormat string   `help:"Format of the missing songs report (csv or json), or auto to infer it from its extension" enum:"auto,csv,json" default:"auto"`
+	InputType     string   `short:"i" help:"Mode to parse input file, inferred from its extension if unset: ${input_types}" optional:""`
+	OutputType    string   `short:"o" help:"Mode to write output file, inferred from its extension if unset: ${output_types}" optional:""`
+
+	SourcePlaylist string `help:"Playlist to convert from inputs holding several, like a Spotify data export" optional:""`
+
+	OutputFlags `embed:""`
+}
+
+var CLI struct {
+	Globals
+
+	Scan       ScanCmd       `cmd:"" help:"Scan search paths and update the db without converting"`
+	Convert    ConvertCmd    `cmd:"" default:"withargs" help:"Convert a playlist using the existing db (default command)"`
+	Batch      BatchCmd      `cmd:"" help:"Convert a directory or glob of playlists using the existing db"`
+	Watch      WatchCmd      `cmd:"" help:"Watch playlists and search paths, reconverting playlists when either changes"`
+	Match      MatchCmd      `cmd:"" help:"Look up a single track in the db"`
+	Duplicates DuplicatesCmd `cmd:"" help:"Report songs with the same artist, album and title"`
+	Library    LibraryCmd    `cmd:"" help:"Inspect the library database"`
 }
 
 func parseConfig(filepath string) common.ConverterConfig {
@@ -73,201 +86,270 @@ func parseConfig(filepath string) common.ConverterConfig {
 	}
 }
 
-func osPathJoin(path1 string, path2 string) string {
-	if runtime.GOOS == "windows" {
-		return path1 + "\\" + path2
+// Loads the config file (if any) and merges in search directories given on the command line.
+func loadConfig(globals *Globals, searchDirs []string) common.ConverterConfig {
+	var config common.ConverterConfig
+	if globals.Config != "" {
+		config = parseConfig(globals.Config)
 	} else {
-		return path1 + "/" + path2
+		config = common.MakeConverterConfig()
 	}
-}
 
-func readSong(filepath string, relpath string) common.Song {
-	song := common.MakeSong()
-	// return song
-	tags, err := taglib.ReadTags(filepath)
-	if err != nil {
-		log.Fatal(err)
-		return song
+	config.PathsFromConfig = config.Paths != nil
+	if len(searchDirs) > 0 && config.Paths == nil {
+		config.Paths = searchDirs
+	} else if len(searchDirs) > 0 {
+		config.Paths = append(config.Paths, searchDirs...)
 	}
 
-	if len(tags[taglib.Album]) > 0 {
-		song.Album = tags[taglib.Album][0]
+	// Normalize search roots so the same directory always maps to the same library partition.
+	for i, path := range config.Paths {
+		if absPath, err := filepath.Abs(path); err == nil {
+			config.Paths[i] = absPath
+		} else {
+			config.Paths[i] = filepath.Clean(path)
+		}
 	}
 
-	if len(tags[taglib.Artist]) > 0 {
-		if len(tags[taglib.Artist]) > 1 {
-			song.Artist = strings.Join(tags[taglib.Artist], ", ")
-		} else {
-			song.Artist = tags[taglib.Artist][0]
+	return config
+}
+
+// Reads the db file, rescanning search paths first if requested.
+func prepareLibrary(globals *Globals, config *common.ConverterConfig, scan bool) (*common.ConverterLibrary, error) {
+	library := common.MakeLibrary()
+	library.TryReadDbFile(globals.DbFile)
+
+	// Only rescan when asked to, so many playlists can be converted against one scan.
+	if scan {
+		if config.Paths == nil {
+			return library, errors.New("no search paths specified")
 		}
+
+		scanLibrary(library, config)
+
+		fmt.Println("Writing database...")
+		library.WriteDbFile(globals.DbFile)
+	} else if library.Len() < 1 {
+		return library, errors.New("library db is empty, run the scan command first")
 	}
 
-	if len(tags[taglib.AlbumArtist]) > 0 {
-		song.AlbumArtist = tags[taglib.AlbumArtist][0]
+	return library, nil
+}
+
+// A single playlist to convert. Empty types are inferred from file extensions.
+type conversion struct {
+	Input         string
+	Output        string
+	InputType     string
+	OutputType    string
+	OutputMissing string
+	MissingFormat string
+	// Name of the playlist to read from inputs holding several, empty to read the only one.
+	SourcePlaylist string
+	OutputFlags
+}
+
+// Reads the input playlist's entries.
+func readPlaylist(conv conversion, config *common.ConverterConfig) ([]readers.ReaderField, error) {
+	var inputType string
+	if conv.InputType != "" {
+		inputType = strings.ToUpper(conv.InputType)
+	} else {
+		inputType = strings.ToUpper(common.GetFileExtension(conv.Input))
 	}
 
-	if len(tags[taglib.Title]) > 0 {
-		song.Title = tags[taglib.Title][0]
+	reader, _, ok := readers.LookupReader(inputType)
+	if !ok {
+		return nil, fmt.Errorf("invalid reader type %s", inputType)
 	}
 
-	if len(tags[taglib.TrackNumber]) > 0 {
-		song.TrackNumber, _ = strconv.Atoi(tags[taglib.TrackNumber][0])
+	var playlist readers.Playlist
+	var err error
+	if conv.SourcePlaylist != "" {
+		multiReader, ok := reader.(readers.MultiPlaylistReader)
+		if !ok {
+			return nil, fmt.Errorf("%s files only hold a single playlist", inputType)
+		}
+		playlist, err = multiReader.ReadPlaylist(conv.Input, conv.SourcePlaylist)
+	} else {
+		playlist, err = reader.Read(conv.Input)
 	}
 
-	song.Filepath = filepath
-	song.Relpath = relpath
+	if err != nil {
+		return nil, err
+	}
 
-	return song
+	fields := playlist.GetFields()
+	if len(fields) < 1 {
+		return nil, fmt.Errorf("no entries read from %s", conv.Input)
+	}
+
+	fillFieldsFromPaths(fields, config)
+
+	return fields, nil
 }
 
-func addSongsRecursive(dir string, reldir string, lib *common.ConverterLibrary, config *common.ConverterConfig) {
-	fileSystem := os.DirFS(dir)
-	fs.WalkDir(fileSystem, ".", func(path string, dirEntry fs.DirEntry, err error) error {
-		if err != nil {
-			return err
+// Fills metadata missing from entries of playlists of files from their paths, the same way
+// scanning does for untagged files: using the configured path pattern, then titling by file name.
+func fillFieldsFromPaths(fields []readers.ReaderField, config *common.ConverterConfig) {
+	pathPattern := compileConfigPathPattern(config)
+	for i, field := range fields {
+		if field.Path == "" {
+			continue
 		}
 
-		if !dirEntry.Type().IsDir() {
-			ext := common.GetFileExtension(dirEntry.Name())
-			if ext != dirEntry.Name() && slices.Contains(WhitelistedFiletypes, strings.ToUpper(ext)) {
-				key := reldir + "/" + path
-				filepath := osPathJoin(dir, path)
-
-				// Only read song metadata if it has not already been loaded from db file
-				if searchedId := lib.GetId(key); searchedId == -1 {
-					id := lib.GetNewId(key)
-					newSong := readSong(filepath, key)
-					lib.Songs[id] = &newSong
-
-					for _, artist := range common.ArtistSplit(newSong.Artist, config) {
-						artist = strings.TrimSpace(artist)
-
-						if artist != common.UnknownArtist {
-							lib.ArtistsIndex[artist] = append(lib.ArtistsIndex[artist], id)
-						}
-					}
-
-					for _, artist := range common.ArtistSplit(newSong.AlbumArtist, config) {
-						artist = strings.TrimSpace(artist)
-
-						if artist != common.UnknownArtist {
-							lib.AlbumArtistsIndex[artist] = append(lib.AlbumArtistsIndex[artist], id)
-						}
-					}
-
-					lib.AlbumsIndex[newSong.Album] = append(lib.AlbumsIndex[newSong.Album], id)
-					lib.TitlesIndex[newSong.Title] = append(lib.TitlesIndex[newSong.Title], id)
-				}
-			}
+		// FillFromPath treats a zero track number as missing, while fields use -1.
+		song := common.Song{
+			Relpath:     field.Path,
+			Artist:      field.Artist,
+			AlbumArtist: field.AlbumArtist,
+			Album:       field.Album,
+			Title:       field.Title,
+			TrackNumber: max(field.TrackNumber, 0),
+		}
+		song.FillFromPath(pathPattern)
+		if song.Title == "" {
+			song.Title = strings.TrimSuffix(path.Base(field.Path), path.Ext(field.Path))
 		}
-		return nil
-	})
-}
-
-func matchSongsInList(config *common.ConverterConfig, list []string, lib *common.ConverterLibrary) []*common.Song {
-	songList := make([]*common.Song, len(list))
 
-	// Very naive and inefficient implementation, maybe TODO streamline
-	for i, val := range list {
-		song := lib.GetSongFromFormatString(val, config)
-		songList[i] = song
+		fields[i].Artist = song.Artist
+		fields[i].AlbumArtist = song.AlbumArtist
+		fields[i].Album = song.Album
+		fields[i].Title = song.Title
+		if song.TrackNumber > 0 {
+			fields[i].TrackNumber = song.TrackNumber
+		}
 	}
-
-	// fmt.Println("Got matches:", songList)
-	return songList
 }
 
-func main() {
-	kong.Parse(&CLI, kong.Description("A utility that takes in a playlist of song metadata and converts it to a relative-pathed playlist."))
-	var config common.ConverterConfig
-	library := common.MakeLibrary()
-
-	if CLI.Config != "" {
-		config = parseConfig(CLI.Config)
+// Returns the writer for a conversion, inferring the output type from the output extension if unset.
+func conversionWriter(conv conversion) (writers.PlaylistWriter, error) {
+	var outputType string
+	if conv.OutputType != "" {
+		outputType = strings.ToUpper(conv.OutputType)
 	} else {
-		config = common.MakeConverterConfig()
+		outputType = strings.ToUpper(common.GetFileExtension(conv.Output))
 	}
 
-	if len(CLI.SearchDirs) > 0 && config.Paths == nil {
-		config.Paths = CLI.SearchDirs
-	} else if len(CLI.SearchDirs) > 0 {
-		config.Paths = append(config.Paths, CLI.SearchDirs...)
+	writer, _, ok := writers.LookupWriter(outputType)
+	if !ok {
+		// Guessing between formats could replace a file another program keeps, like Rhythmbox's playlists.xml.
+		if names := writers.WriterNamesForExtension(outputType); len(names) > 1 {
+			return nil, fmt.Errorf("%s files are written by %s, choose one with --output-type", outputType, strings.Join(names, " and "))
+		}
+		return nil, fmt.Errorf("invalid writer type %s", outputType)
 	}
 
-	if config.Paths == nil {
-		fmt.Println("ERROR: No search paths specified! Unable to continue.")
-		return
-	}
+	return writer, nil
+}
 
-	library.TryReadDbFile(CLI.DbFile)
+// Returns the conversion's playlist name with {Name} replaced by the input's file name,
+// or the source playlist's name when reading one of several.
+func playlistName(conv conversion) string {
+	return strings.ReplaceAll(conv.PlaylistName, "{Name}", sourceName(conv))
+}
 
-	fmt.Println("Building database...")
-	for _, path := range config.Paths {
-		fmt.Println("Reading", path)
-		addSongsRecursive(path, filepath.Base(path), &library, &config)
+// Returns the name of the playlist being read, from inside the input or its file name.
+func sourceName(conv conversion) string {
+	if conv.SourcePlaylist != "" {
+		return conv.SourcePlaylist
 	}
 
-	fmt.Println("Writing database...")
-	library.WriteDbFile(CLI.DbFile)
+	return inputName(conv.Input)
+}
 
-	fmt.Println("Reading input playlist...")
+// Writes the matched songs to the conversion's output, plus the missing songs report if requested.
+func writePlaylist(conv conversion, config *common.ConverterConfig, library *common.ConverterLibrary, fields []readers.ReaderField, songList []*common.Song) error {
+	writer, err := conversionWriter(conv)
+	if err != nil {
+		return err
+	}
 
-	var inputType string
-	if CLI.InputType != "" {
-		inputType = strings.ToUpper(CLI.InputType)
+	if conv.OutputMissing != "" {
+		report := missingReport(config, library, fields, songList)
+		if err := writeMissingReport(conv.OutputMissing, missingReportFormat(conv.OutputMissing, conv.MissingFormat), report); err != nil {
+			fmt.Println("ERROR: Error writing missing songs report", conv.OutputMissing+":", err)
+		}
+	}
+
+	pathOptions := conv.pathOptions()
+	if absOutput, err := filepath.Abs(conv.Output); err == nil {
+		pathOptions.OutputDir = filepath.Dir(absOutput)
 	} else {
-		inputType = strings.ToUpper(common.GetFileExtension(CLI.Input))
+		pathOptions.OutputDir = filepath.Dir(conv.Output)
+	}
+	if len(pathOptions.Prefixes) < 1 {
+		pathOptions.Prefixes = config.PathPrefixes
 	}
 
-	var reader readers.PlaylistReader
-	if slices.Contains(InputTypes, inputType) {
-		if inputType == "CSV" {
-			reader = readers.ReadCsv(CLI.Input, "default")
-		} else if inputType == "EXPORTIFY" {
-			reader = readers.ReadCsv(CLI.Input, "exportify")
+	fmt.Println("Writing output playlist", conv.Output+"...")
+
+	// Describe unmatched entries so writers can keep their place in the playlist.
+	missing := make([]string, len(songList))
+	for i, song := range songList {
+		if song == nil {
+			missing[i] = fields[i].Describe()
 		}
-	} else {
-		panic(fmt.Sprintln("Invalid reader type", inputType))
 	}
 
-	keyList := reader.GetKeyList(config.Format)
-	if len(keyList) < 1 {
-		panic("Keylist empty from reader")
+	return writer.Write(conv.Output, writers.Playlist{
+		Name:        playlistName(conv),
+		SourceName:  sourceName(conv),
+		Songs:       songList,
+		Missing:     missing,
+		MissingMode: conv.Missing,
+		Placeholder: conv.Placeholder,
+		Paths:       pathOptions,
+	})
+}
+
+// Reads, matches and writes a single playlist against an already loaded library.
+func convertPlaylist(conv conversion, config *common.ConverterConfig, library *common.ConverterLibrary) error {
+	if _, err := conversionWriter(conv); err != nil {
+		return err
 	}
 
-	var outputType string
-	if CLI.OutputType != "" {
-		outputType = strings.ToUpper(CLI.OutputType)
-	} else {
-		outputType = strings.ToUpper(common.GetFileExtension(CLI.Output))
+	fmt.Println("Reading input playlist", conv.Input+"...")
+	fields, err := readPlaylist(conv, config)
+	if err != nil {
+		return err
 	}
 
 	fmt.Println("Matching playlist items...")
-	songList := matchSongsInList(&config, keyList, &library)
-
-	if CLI.OutputMissing != "" {
-		f, err := os.Create(CLI.OutputMissing)
-		if err == nil {
-			f.WriteString("Couldn't find:\n")
-			for i, song := range songList {
-				if song == nil {
-					f.WriteString(keyList[i] + "\n")
-				}
-			}
-		} else {
-			fmt.Println("ERROR: Error printing to", CLI.OutputMissing)
-		}
+	songList := matchSongsInList(config, fields, library)
+
+	return writePlaylist(conv, config, library, fields, songList)
+}
 
-		f.Close()
+func (cmd *ConvertCmd) Run(globals *Globals) error {
+	config := loadConfig(globals, cmd.SearchDirs)
+	library, err := prepareLibrary(globals, &config, cmd.Scan || len(cmd.SearchDirs) > 0)
+	if err != nil {
+		return err
 	}
 
-	fmt.Println("Writing output playlist...")
+	return convertPlaylist(conversion{
+		Input:          cmd.Input,
+		Output:         cmd.Output,
+		InputType:      cmd.InputType,
+		OutputType:     cmd.OutputType,
+		OutputMissing:  cmd.OutputMissing,
+		MissingFormat:  cmd.MissingFormat,
+		SourcePlaylist: cmd.SourcePlaylist,
+		OutputFlags:    cmd.OutputFlags,
+	}, &config, library)
+}
 
-	if slices.Contains(OutputTypes, outputType) {
-		if outputType == "M3U" {
-			writers.WriteM3U(CLI.Output, songList)
-		}
-	} else {
-		panic(fmt.Sprintln("Invalid reader type", inputType))
-	}
+func main() {
+	ctx := kong.Parse(&CLI,
+		kong.Description("A utility that takes in a playlist of song metadata and converts it to a relative-pathed playlist."),
+		kong.Vars{
+			"input_types":   common.DescribeFormats(readers.ReaderFormats()),
+			"output_types":  common.DescribeFormats(writers.WriterFormats()),
+			"path_styles":   strings.Join(writers.PathStyles, ","),
+			"path_modes":    strings.Join(writers.PathModes, ","),
+			"missing_modes": strings.Join(writers.MissingModes, ","),
+		},
+	)
+	ctx.FatalIfErrorf(ctx.Run(&CLI.Globals))
 }
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	common "dstet.me/p2m3u/common"
+)
+
+type DuplicatesCmd struct {
+	Duration    time.Duration `help:"Only group songs whose durations are within this tolerance, e.g. 2s. Disabled by default"`
+	Fingerprint bool          `help:"Group songs by fingerprint similarity instead of metadata"`
+}
+
+func (cmd *DuplicatesCmd) Run(globals *Globals) error {
+	config := loadConfig(globals, nil)
+	library := loadLibrary(globals)
+
+	var groups [][]*common.Song
+	if cmd.Fingerprint {
+		groups = library.GetFingerprintDuplicateGroups(&config)
+	} else {
+		groups = library.GetDuplicateGroups(cmd.Duration, &config)
+	}
+
+	for _, group := range groups {
+		fmt.Println(group[0].Artist + " - " + group[0].Album + " - " + group[0].Title)
+		for i, song := range group {
+			// The first song in each group is the one the matcher would choose.
+			marker := " "
+			if i == 0 {
+				marker = "*"
+			}
+			fmt.Printf("  %s %s\t%s\t%d kbps\t%s\n", marker, song.Filepath, song.GetFiletype(), song.Bitrate, song.Duration.Round(time.Second))
+		}
+	}
+
+	fmt.Println("Found", len(groups), "duplicate groups")
+	return nil
+}
@@ -3,8 +3,9 @@ module dstet.me/p2m3u
 go 1.24.0
 
 require (
-	github.com/alecthomas/kong v1.8.1 // indirect
-	github.com/pelletier/go-toml/v2 v2.2.3 // indirect
-	github.com/tetratelabs/wazero v1.8.2 // indirect
-	go.senan.xyz/taglib v0.6.1 // indirect
+	github.com/alecthomas/kong v1.8.1
+	github.com/pelletier/go-toml/v2 v2.2.3
+	go.senan.xyz/taglib v0.6.1
 )
+
+require github.com/tetratelabs/wazero v1.8.2 // indirect
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	common "dstet.me/p2m3u/common"
+)
+
+type LibraryCmd struct {
+	List   LibraryListCmd   `cmd:"" help:"List every song in the library"`
+	Search LibrarySearchCmd `cmd:"" help:"Search the library by artist, album, title or path"`
+	Stats  LibraryStatsCmd  `cmd:"" help:"Print library statistics"`
+	Export LibraryExportCmd `cmd:"" help:"Export the library as CSV or JSON"`
+}
+
+type LibraryListCmd struct{}
+
+type LibrarySearchCmd struct {
+	Query string `arg:"" help:"Case-insensitive text to search for"`
+}
+
+type LibraryStatsCmd struct{}
+
+type LibraryExportCmd struct {
+	Format string `short:"f" help:"Export format (csv or json)" enum:"csv,json" default:"csv"`
+	Output string `short:"O" help:"File to export to, defaults to stdout" type:"path" optional:""`
+}
+
+// Reads the db file without scanning any search paths.
+func loadLibrary(globals *Globals) *common.ConverterLibrary {
+	library := common.MakeLibrary()
+	library.TryReadDbFile(globals.DbFile)
+	return library
+}
+
+// Formats a song as a single human-readable line. The full path is used since
+// relpaths of songs under same-named search roots are identical.
+func formatSongLine(song *common.Song) string {
+	return song.Artist + " - " + song.Album + " - " + song.Title + "\t" + song.Filepath
+}
+
+func (cmd *LibraryListCmd) Run(globals *Globals) error {
+	library := loadLibrary(globals)
+	for _, song := range library.SortedSongs() {
+		fmt.Println(formatSongLine(song))
+	}
+
+	return nil
+}
+
+func (cmd *LibrarySearchCmd) Run(globals *Globals) error {
+	library := loadLibrary(globals)
+	query := strings.ToLower(cmd.Query)
+
+	for _, song := range library.SortedSongs() {
+		for _, field := range []string{song.Artist, song.AlbumArtist, song.Album, song.Title, song.Filepath} {
+			if strings.Contains(strings.ToLower(field), query) {
+				fmt.Println(formatSongLine(song))
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+func (cmd *LibraryStatsCmd) Run(globals *Globals) error {
+	library := loadLibrary(globals)
+
+	roots := make(map[string]int)
+	filetypes := make(map[string]int)
+	songs := library.SortedSongs()
+	for _, song := range songs {
+		roots[song.Root]++
+		filetypes[song.GetFiletype()]++
+	}
+
+	artists, albumArtists, albums := library.IndexSizes()
+	fmt.Println("Songs:", len(songs))
+	fmt.Println("Artists:", artists)
+	fmt.Println("Album artists:", albumArtists)
+	fmt.Println("Albums:", albums)
+
+	fmt.Println("Search roots:")
+	for _, root := range slices.Sorted(maps.Keys(roots)) {
+		fmt.Println("\t"+root+":", roots[root])
+	}
+
+	fmt.Println("Filetypes:")
+	for _, filetype := range slices.Sorted(maps.Keys(filetypes)) {
+		fmt.Println("\t"+filetype+":", filetypes[filetype])
+	}
+
+	return nil
+}
+
+func (cmd *LibraryExportCmd) Run(globals *Globals) error {
+	library := loadLibrary(globals)
+	songs := library.SortedSongs()
+
+	var out io.Writer = os.Stdout
+	if cmd.Output != "" {
+		f, err := os.Create(cmd.Output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	if cmd.Format == "json" {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(songs)
+	}
+
+	writer := csv.NewWriter(out)
+	writer.Write([]string{"Root", "Relpath", "Filepath", "Artist", "AlbumArtist", "Album", "Title", "Track Number"})
+	for _, song := range songs {
+		writer.Write([]string{
+			song.Root,
+			song.Relpath,
+			song.Filepath,
+			song.Artist,
+			song.AlbumArtist,
+			song.Album,
+			song.Title,
+			strconv.Itoa(song.TrackNumber),
+		})
+	}
+	writer.Flush()
+
+	return writer.Error()
+}
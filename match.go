@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+
+	common "dstet.me/p2m3u/common"
+	readers "dstet.me/p2m3u/readers"
+)
+
+type MatchCmd struct {
+	Artist      string `help:"Track artist"`
+	AlbumArtist string `help:"Track album artist"`
+	Album       string `help:"Track album"`
+	Title       string `help:"Track title"`
+	Track       int    `help:"Track number" default:"-1"`
+	Fingerprint string `help:"Chromaprint fingerprint, as printed by fpcalc"`
+	Candidates  int    `short:"n" help:"Number of candidates to show" default:"5"`
+}
+
+func (cmd *MatchCmd) Run(globals *Globals) error {
+	config := loadConfig(globals, nil)
+	library := loadLibrary(globals)
+
+	field := readers.ReaderField{
+		Artist:      cmd.Artist,
+		AlbumArtist: cmd.AlbumArtist,
+		Album:       cmd.Album,
+		Title:       cmd.Title,
+		TrackNumber: cmd.Track,
+		Fingerprint: cmd.Fingerprint,
+	}
+	key := field.GetKey(config.Format)
+
+	if song := matchSongsInList(&config, []readers.ReaderField{field}, library)[0]; song != nil {
+		fmt.Println("Match:", formatSongLine(song))
+	} else {
+		fmt.Println("No match above minimum allowance of", config.MinimumMatchAllowance)
+	}
+
+	for i, candidate := range library.GetRankedCandidates(key, &config) {
+		if i >= cmd.Candidates {
+			break
+		}
+		fmt.Printf("%.2f\t%s\n", candidate.Score, formatSongLine(candidate.Song))
+	}
+
+	return nil
+}
+
+func matchSongsInList(config *common.ConverterConfig, list []readers.ReaderField, lib *common.ConverterLibrary) []*common.Song {
+	songList := make([]*common.Song, len(list))
+
+	// Very naive and inefficient implementation, maybe TODO streamline
+	for i, field := range list {
+		// Empty entries would only match untagged songs through their "Unknown" keys.
+		if field.IsEmpty() {
+			continue
+		}
+
+		song := lib.GetSongFromFormatString(field.GetKey(config.Format), config)
+
+		// Fall back to fingerprints for entries that carry one when the metadata does not match.
+		if song == nil && field.Fingerprint != "" {
+			if fingerprint, err := common.DecodeFingerprint(field.Fingerprint); err == nil {
+				song, _ = lib.GetSongFromFingerprint(fingerprint, config)
+			} else {
+				fmt.Println("ERROR: Invalid fingerprint for", field.Title+":", err)
+			}
+		}
+
+		songList[i] = song
+	}
+
+	// fmt.Println("Got matches:", songList)
+	return songList
+}
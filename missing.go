@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"strconv"
+	"strings"
+
+	common "dstet.me/p2m3u/common"
+	readers "dstet.me/p2m3u/readers"
+)
+
+// A playlist entry that could not be matched, with the closest song in the library.
+type missingEntry struct {
+	// 1-based position in the input playlist.
+	Position    int
+	Artist      string
+	AlbumArtist string
+	Album       string
+	Title       string
+	TrackNumber int
+	// Full path of the highest scoring candidate, even though it is below the match allowance.
+	BestCandidate string
+	Score         float32
+}
+
+// Collects the unmatched entries of a playlist along with their best candidates.
+func missingReport(config *common.ConverterConfig, library *common.ConverterLibrary, fields []readers.ReaderField, songList []*common.Song) []missingEntry {
+	var entries []missingEntry
+	for i, song := range songList {
+		if song != nil {
+			continue
+		}
+
+		entry := missingEntry{
+			Position:    i + 1,
+			Artist:      fields[i].Artist,
+			AlbumArtist: fields[i].AlbumArtist,
+			Album:       fields[i].Album,
+			Title:       fields[i].Title,
+			TrackNumber: fields[i].TrackNumber,
+		}
+		if candidates := library.GetRankedCandidates(fields[i].GetKey(config.Format), config); len(candidates) > 0 {
+			entry.BestCandidate = candidates[0].Song.Filepath
+			entry.Score = candidates[0].Score
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
+
+// Returns the report format, inferred from the report's extension if auto or unset.
+func missingReportFormat(filename string, format string) string {
+	if format != "" && format != "auto" {
+		return format
+	}
+
+	if strings.ToUpper(common.GetFileExtension(filename)) == "JSON" {
+		return "json"
+	}
+
+	return "csv"
+}
+
+// Writes the missing songs report as CSV or JSON. The CSV uses the default CSV reader's
+// column names, so a report can be converted again once the songs are in the library.
+func writeMissingReport(filename string, format string, entries []missingEntry) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if format == "json" {
+		encoder := json.NewEncoder(f)
+		encoder.SetIndent("", "  ")
+		if entries == nil {
+			entries = []missingEntry{}
+		}
+		return encoder.Encode(entries)
+	}
+
+	writer := csv.NewWriter(f)
+	writer.Write([]string{"Position", "Artist", "AlbumArtist", "Album", "Title", "Track Number", "Best Candidate", "Score"})
+	for _, entry := range entries {
+		var score string
+		if entry.BestCandidate != "" {
+			score = strconv.FormatFloat(float64(entry.Score), 'f', 2, 32)
+		}
+
+		writer.Write([]string{
+			strconv.Itoa(entry.Position),
+			entry.Artist,
+			entry.AlbumArtist,
+			entry.Album,
+			entry.Title,
+			strconv.Itoa(entry.TrackNumber),
+			entry.BestCandidate,
+			score,
+		})
+	}
+	writer.Flush()
+
+	return writer.Error()
+}
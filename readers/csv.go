@@ -2,17 +2,43 @@ package readers
 
 import (
 	"encoding/csv"
+	"errors"
 	"fmt"
 	"os"
 	"strconv"
+
+	common "dstet.me/p2m3u/common"
 )
 
+// Reads CSV playlists with one of the column templates below.
+type CsvReader struct {
+	CsvType string
+}
+
+func init() {
+	RegisterReader(common.PlaylistFormat{
+		Name:        "CSV",
+		Extensions:  []string{"CSV"},
+		Description: "CSV with Artist, Album and Title columns",
+	}, CsvReader{CsvType: "default"})
+	RegisterReader(common.PlaylistFormat{
+		Name:        "EXPORTIFY",
+		Extensions:  []string{"EXPORTIFY"},
+		Description: "Exportify CSV",
+	}, CsvReader{CsvType: "exportify"})
+}
+
+func (r CsvReader) Read(filename string) (Playlist, error) {
+	return ReadCsv(filename, r.CsvType)
+}
+
 type csvFields struct {
 	ArtistField      string
 	AlbumArtistField string
 	TitleField       string
 	AlbumField       string
 	TrackNumberField string
+	FingerprintField string
 }
 
 var fieldsTemplate = map[string]csvFields{
@@ -22,6 +48,7 @@ var fieldsTemplate = map[string]csvFields{
 		TitleField:       "Title",
 		AlbumField:       "Album",
 		TrackNumberField: "Track Number",
+		FingerprintField: "Fingerprint",
 	},
 	"exportify": csvFields{
 		ArtistField:      "Artist Name(s)",
@@ -32,26 +59,28 @@ var fieldsTemplate = map[string]csvFields{
 	},
 }
 
-func ReadCsv(filename string, csvType string) PlaylistReader {
+func ReadCsv(filename string, csvType string) (Playlist, error) {
 	csvFields := fieldsTemplate[csvType]
 
 	ioReader, fileErr := os.Open(filename)
 	if fileErr != nil {
-		panic(fileErr)
+		return Playlist{}, fileErr
 	}
+	defer ioReader.Close()
 
 	reader := csv.NewReader(ioReader)
 	records, err := reader.ReadAll()
 	if err != nil {
-		panic(err)
+		return Playlist{}, err
 	}
 
-	csvReader := PlaylistReader{}
+	csvReader := Playlist{}
 	artistIdx := -1
 	albumArtistIdx := -1
 	titleIdx := -1
 	albumIdx := -1
 	trackNumIdx := -1
+	fingerprintIdx := -1
 	for i, record := range records {
 		if i == 0 {
 			for j, field := range record {
@@ -65,6 +94,8 @@ func ReadCsv(filename string, csvType string) PlaylistReader {
 					albumIdx = j
 				} else if field == csvFields.TrackNumberField {
 					trackNumIdx = j
+				} else if csvFields.FingerprintField != "" && field == csvFields.FingerprintField {
+					fingerprintIdx = j
 				}
 			}
 
@@ -72,8 +103,9 @@ func ReadCsv(filename string, csvType string) PlaylistReader {
 				albumArtistIdx == -1 &&
 				titleIdx == -1 &&
 				albumIdx == -1 &&
-				trackNumIdx == -1 {
-				panic("Input CSV does not include valid header")
+				trackNumIdx == -1 &&
+				fingerprintIdx == -1 {
+				return Playlist{}, errors.New("input CSV does not include valid header")
 			}
 		} else {
 			field := ReaderField{TrackNumber: -1}
@@ -102,9 +134,13 @@ func ReadCsv(filename string, csvType string) PlaylistReader {
 				field.TrackNumber = -1
 			}
 
+			if fingerprintIdx != -1 {
+				field.Fingerprint = record[fingerprintIdx]
+			}
+
 			csvReader.fields = append(csvReader.fields, field)
 		}
 	}
 
-	return csvReader
+	return csvReader, nil
 }
@@ -0,0 +1,191 @@
+package readers
+
+import (
+	"bufio"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	common "dstet.me/p2m3u/common"
+	"go.senan.xyz/taglib"
+)
+
+// Reads M3U and extended M3U playlists of file paths, so playlists can be re-pointed at a reorganised library.
+// Metadata comes from each file's tags if it still exists, then its #EXTINF line. Anything still missing
+// is inferred from the path by the caller.
+type M3UReader struct{}
+
+func init() {
+	RegisterReader(common.PlaylistFormat{
+		Name:        "M3U",
+		Extensions:  []string{"M3U", "M3U8"},
+		Description: "M3U or extended M3U of file paths",
+	}, M3UReader{})
+}
+
+func (r M3UReader) Read(filename string) (Playlist, error) {
+	ioReader, err := os.Open(filename)
+	if err != nil {
+		return Playlist{}, err
+	}
+	defer ioReader.Close()
+
+	playlist := Playlist{}
+	playlistDir := filepath.Dir(filename)
+
+	var extinf ReaderField
+	hasExtinf := false
+	scanner := bufio.NewScanner(ioReader)
+	for scanner.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\uFEFF"))
+		// Plain M3U files are often in a legacy encoding rather than UTF-8.
+		if !utf8.ValidString(line) {
+			line = decodeLatin1(line)
+		}
+
+		if line == "" {
+			continue
+		} else if strings.HasPrefix(line, "#EXTINF:") {
+			extinf = parseExtinf(strings.TrimPrefix(line, "#EXTINF:"))
+			hasExtinf = true
+			continue
+		} else if strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		field := ReaderField{TrackNumber: -1, Path: m3uEntryPath(line, playlistDir)}
+		readFieldTags(&field)
+		if hasExtinf {
+			if field.Artist == "" {
+				field.Artist = extinf.Artist
+			}
+			if field.Title == "" {
+				field.Title = extinf.Title
+			}
+			field.Duration = extinf.Duration
+		}
+
+		playlist.fields = append(playlist.fields, field)
+		hasExtinf = false
+	}
+
+	return playlist, scanner.Err()
+}
+
+// Parses "<duration>,<Artist> - <Title>" from an #EXTINF line. Titles without " - " have no artist.
+func parseExtinf(value string) ReaderField {
+	var field ReaderField
+
+	// Attributes like tvg-name="..." may follow the duration, and their quoted values may contain commas.
+	split := -1
+	quoted := false
+	for i, r := range value {
+		if r == '"' {
+			quoted = !quoted
+		} else if r == ',' && !quoted {
+			split = i
+			break
+		}
+	}
+	if split < 0 {
+		return field
+	}
+	duration, display := value[:split], value[split+1:]
+
+	durationFields := strings.Fields(duration)
+	if len(durationFields) > 0 {
+		if seconds, err := strconv.ParseFloat(durationFields[0], 64); err == nil && seconds > 0 {
+			field.Duration = time.Duration(seconds * float64(time.Second))
+		}
+	}
+
+	display = strings.TrimSpace(display)
+	if artist, title, found := strings.Cut(display, " - "); found {
+		field.Artist = strings.TrimSpace(artist)
+		field.Title = strings.TrimSpace(title)
+	} else if artist, found := strings.CutSuffix(display, " -"); found {
+		// Trailing whitespace is trimmed from lines, so an empty title leaves only " -".
+		field.Artist = strings.TrimSpace(artist)
+	} else if title, found := strings.CutPrefix(display, "- "); found {
+		// Likewise, an empty artist leaves only "- ".
+		field.Title = strings.TrimSpace(title)
+	} else {
+		field.Title = display
+	}
+
+	return field
+}
+
+// Returns an entry's path as an absolute slash-separated path where possible.
+// Entries may be file URIs, absolute paths or paths relative to the playlist.
+func m3uEntryPath(entry string, playlistDir string) string {
+	if strings.HasPrefix(strings.ToLower(entry), "file:") {
+		if uri, err := url.Parse(entry); err == nil {
+			entry = uri.Path
+			// file:///C:/Music parses to /C:/Music.
+			if len(entry) > 2 && entry[0] == '/' && entry[2] == ':' {
+				entry = entry[1:]
+			}
+		}
+	}
+
+	entry = strings.ReplaceAll(entry, "\\", "/")
+	if filepath.IsAbs(filepath.FromSlash(entry)) || (len(entry) > 1 && entry[1] == ':') || strings.HasPrefix(entry, "/") {
+		return entry
+	}
+
+	return filepath.ToSlash(filepath.Join(playlistDir, filepath.FromSlash(entry)))
+}
+
+// Fills the field from the tags of the file at its path, if it still exists.
+func readFieldTags(field *ReaderField) {
+	filename := filepath.FromSlash(field.Path)
+	if _, err := os.Stat(filename); err != nil {
+		return
+	}
+
+	tags, err := taglib.ReadTags(filename)
+	if err != nil {
+		return
+	}
+
+	if len(tags[taglib.Artist]) > 0 {
+		field.Artist = strings.Join(tags[taglib.Artist], ", ")
+	}
+
+	if len(tags[taglib.AlbumArtist]) > 0 {
+		field.AlbumArtist = tags[taglib.AlbumArtist][0]
+	}
+
+	if len(tags[taglib.Album]) > 0 {
+		field.Album = tags[taglib.Album][0]
+	}
+
+	if len(tags[taglib.Title]) > 0 {
+		field.Title = tags[taglib.Title][0]
+	}
+
+	if len(tags[taglib.TrackNumber]) > 0 {
+		if trackNumber, err := strconv.Atoi(strings.Split(tags[taglib.TrackNumber][0], "/")[0]); err == nil {
+			field.TrackNumber = trackNumber
+		}
+	}
+
+	if len(tags[taglib.AcoustIDFingerprint]) > 0 {
+		field.Fingerprint = tags[taglib.AcoustIDFingerprint][0]
+	}
+}
+
+// Decodes a Latin-1 (ISO 8859-1) string, whose bytes map directly to the first 256 code points.
+func decodeLatin1(value string) string {
+	runes := make([]rune, len(value))
+	for i := 0; i < len(value); i++ {
+		runes[i] = rune(value[i])
+	}
+
+	return string(runes)
+}
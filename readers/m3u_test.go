@@ -0,0 +1,116 @@
+package readers
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseExtinf(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected ReaderField
+	}{
+		{"215,Artist - Title", ReaderField{Artist: "Artist", Title: "Title", Duration: 215 * time.Second}},
+		{"215.5,Artist - Title - Live", ReaderField{Artist: "Artist", Title: "Title - Live", Duration: 215500 * time.Millisecond}},
+		{"-1,Artist - Title", ReaderField{Artist: "Artist", Title: "Title"}},
+		{"180 tvg-name=\"x,y\" group-title=\"Music\",Artist - Title", ReaderField{Artist: "Artist", Title: "Title", Duration: 180 * time.Second}},
+		{"180 group-title=\"Music\",Artist - Title", ReaderField{Artist: "Artist", Title: "Title", Duration: 180 * time.Second}},
+		{"100,Just A Title", ReaderField{Title: "Just A Title", Duration: 100 * time.Second}},
+		{"100,Artist -", ReaderField{Artist: "Artist", Duration: 100 * time.Second}},
+		{"100, - Title", ReaderField{Title: "Title", Duration: 100 * time.Second}},
+		{"100,", ReaderField{Duration: 100 * time.Second}},
+		{"no comma", ReaderField{}},
+		{"abc,Artist - Title", ReaderField{Artist: "Artist", Title: "Title"}},
+	}
+
+	for _, test := range tests {
+		if field := parseExtinf(test.value); field != test.expected {
+			t.Errorf("parseExtinf(%q) = %+v, expected %+v", test.value, field, test.expected)
+		}
+	}
+}
+
+func TestM3UEntryPath(t *testing.T) {
+	playlistDir := filepath.FromSlash("/music/lists")
+
+	tests := []struct {
+		entry    string
+		expected string
+	}{
+		{"/music/Album/song.flac", "/music/Album/song.flac"},
+		{"song.flac", "/music/lists/song.flac"},
+		{"../Album/song.flac", "/music/Album/song.flac"},
+		// Playlists written on Windows use backslashes, even for relative paths.
+		{"..\\Album\\song.flac", "/music/Album/song.flac"},
+		{"C:\\Music\\Album\\song.flac", "C:/Music/Album/song.flac"},
+		{"C:/Music/Album/song.flac", "C:/Music/Album/song.flac"},
+		{"file:///music/Album/song%20one.flac", "/music/Album/song one.flac"},
+		{"file:///C:/Music/Album/song%20one.flac", "C:/Music/Album/song one.flac"},
+		{"FILE:///music/Album/song.flac", "/music/Album/song.flac"},
+	}
+
+	for _, test := range tests {
+		if path := m3uEntryPath(test.entry, playlistDir); path != test.expected {
+			t.Errorf("m3uEntryPath(%q) = %q, expected %q", test.entry, path, test.expected)
+		}
+	}
+}
+
+func TestDecodeLatin1(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected string
+	}{
+		{"plain", "plain"},
+		{"Beyonc\xe9", "Beyoncé"},
+		{"\xc4\xd6\xdc \xdf", "ÄÖÜ ß"},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		if decoded := decodeLatin1(test.value); decoded != test.expected {
+			t.Errorf("decodeLatin1(%q) = %q, expected %q", test.value, decoded, test.expected)
+		}
+	}
+}
+
+func TestM3UReaderRead(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "list.m3u")
+	contents := "\uFEFF#EXTM3U\r\n" +
+		"#EXTINF:215,Artist - Title\r\n" +
+		"Album/song.flac\r\n" +
+		"\r\n" +
+		"# A comment\r\n" +
+		"#EXTINF:100,Beyonc\xe9 - Halo\r\n" +
+		"Album\\halo.mp3\r\n" +
+		"/elsewhere/untitled.mp3\r\n"
+	if err := os.WriteFile(filename, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	playlist, err := M3UReader{}.Read(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dirPath := filepath.ToSlash(dir)
+	expected := []ReaderField{
+		{Artist: "Artist", Title: "Title", TrackNumber: -1, Duration: 215 * time.Second, Path: dirPath + "/Album/song.flac"},
+		{Artist: "Beyoncé", Title: "Halo", TrackNumber: -1, Duration: 100 * time.Second, Path: dirPath + "/Album/halo.mp3"},
+		// #EXTINF only applies to the entry that follows it.
+		{TrackNumber: -1, Path: "/elsewhere/untitled.mp3"},
+	}
+
+	fields := playlist.GetFields()
+	if len(fields) != len(expected) {
+		t.Fatalf("expected %d entries, got %d: %+v", len(expected), len(fields), fields)
+	}
+	for i := range expected {
+		if fields[i] != expected[i] {
+			t.Errorf("entry %d = %+v, expected %+v", i, fields[i], expected[i])
+		}
+	}
+}
@@ -3,6 +3,7 @@ package readers
 import (
 	"strconv"
 	"strings"
+	"time"
 
 	common "dstet.me/p2m3u/common"
 )
@@ -13,47 +14,76 @@ type ReaderField struct {
 	Album       string
 	Artist      string
 	TrackNumber int
+	// Compressed Chromaprint fingerprint, if the playlist provides one.
+	Fingerprint string
+	// Zero if the playlist does not provide one.
+	Duration time.Duration
+	Isrc     string
+	// Slash-separated path of the song in playlists of files, used to infer missing metadata.
+	Path string
 }
 
-type PlaylistReader struct {
+// Entries read from a playlist file.
+type Playlist struct {
 	fields []ReaderField
 }
 
-func (r PlaylistReader) GetKeyList(format string) []string {
+func (r Playlist) GetFields() []ReaderField {
+	return r.fields
+}
+
+func (r Playlist) GetKeyList(format string) []string {
 	var keys []string
 
-	splitFormat := strings.Split(format, common.FormatSeparatorCharacter)
 	for _, field := range r.fields {
-		var key strings.Builder
-		for i, ident := range splitFormat {
-			var identVal string
-
-			if ident == common.AlbumFormat {
-				identVal = field.Album
-			} else if ident == common.AlbumArtistFormat {
-				identVal = field.AlbumArtist
-			} else if ident == common.ArtistFormat {
-				identVal = field.Artist
-			} else if ident == common.TitleFormat {
-				identVal = field.Title
-			} else if ident == common.TrackNumberFormat {
-				identVal = strconv.Itoa(field.TrackNumber)
-			}
-
-			if identVal == "" {
-				identVal = "Unknown"
-			}
-
-			key.WriteString(identVal)
-
-			// Only append '/' on nonfinal idents
-			if i < len(splitFormat)-1 {
-				key.WriteString(common.FormatSeparatorCharacter)
-			}
+		keys = append(keys, field.GetKey(format))
+	}
+
+	return keys
+}
+
+// Returns true for entries with nothing to match, like podcast episodes, which only keep their position in the playlist.
+func (field ReaderField) IsEmpty() bool {
+	return field.Title == "" && field.Artist == "" && field.AlbumArtist == "" && field.Album == "" &&
+		field.Fingerprint == "" && field.Isrc == "" && field.Path == ""
+}
+
+// Returns "Artist - Album - Title", for describing entries that could not be matched.
+func (field ReaderField) Describe() string {
+	return field.Artist + " - " + field.Album + " - " + field.Title
+}
+
+// Builds the matching key for a single field according to the format string.
+func (field ReaderField) GetKey(format string) string {
+	var key strings.Builder
+
+	splitFormat := strings.Split(format, common.FormatSeparatorCharacter)
+	for i, ident := range splitFormat {
+		var identVal string
+
+		if ident == common.AlbumFormat {
+			identVal = field.Album
+		} else if ident == common.AlbumArtistFormat {
+			identVal = field.AlbumArtist
+		} else if ident == common.ArtistFormat {
+			identVal = field.Artist
+		} else if ident == common.TitleFormat {
+			identVal = field.Title
+		} else if ident == common.TrackNumberFormat {
+			identVal = strconv.Itoa(field.TrackNumber)
 		}
 
-		keys = append(keys, key.String())
+		if identVal == "" {
+			identVal = "Unknown"
+		}
+
+		key.WriteString(identVal)
+
+		// Only append '/' on nonfinal idents
+		if i < len(splitFormat)-1 {
+			key.WriteString(common.FormatSeparatorCharacter)
+		}
 	}
 
-	return keys
+	return key.String()
 }
@@ -0,0 +1,60 @@
+package readers
+
+import (
+	"strings"
+
+	common "dstet.me/p2m3u/common"
+)
+
+// Reads a playlist file into its entries.
+type PlaylistReader interface {
+	Read(filename string) (Playlist, error)
+}
+
+// Implemented by readers of files that can hold several playlists, like Spotify's data export.
+type MultiPlaylistReader interface {
+	PlaylistReader
+	// Returns the names of the playlists in the file, in order.
+	PlaylistNames(filename string) ([]string, error)
+	// Reads a single playlist from the file by name.
+	ReadPlaylist(filename string, name string) (Playlist, error)
+}
+
+type registeredReader struct {
+	format common.PlaylistFormat
+	reader PlaylistReader
+}
+
+var readerRegistry []registeredReader
+
+// Adds a reader for the format. Formats register themselves from their own file's init function.
+func RegisterReader(format common.PlaylistFormat, reader PlaylistReader) {
+	readerRegistry = append(readerRegistry, registeredReader{format: format, reader: reader})
+}
+
+// Returns the reader and format for a type name or file extension. Type names take priority over extensions.
+func LookupReader(typeName string) (PlaylistReader, common.PlaylistFormat, bool) {
+	for _, registered := range readerRegistry {
+		if registered.format.Name == strings.ToUpper(typeName) {
+			return registered.reader, registered.format, true
+		}
+	}
+
+	for _, registered := range readerRegistry {
+		if registered.format.Matches(typeName) {
+			return registered.reader, registered.format, true
+		}
+	}
+
+	return nil, common.PlaylistFormat{}, false
+}
+
+// Returns every registered input format, in registration order.
+func ReaderFormats() []common.PlaylistFormat {
+	var formats []common.PlaylistFormat
+	for _, registered := range readerRegistry {
+		formats = append(formats, registered.format)
+	}
+
+	return formats
+}
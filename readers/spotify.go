@@ -0,0 +1,263 @@
+package readers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	common "dstet.me/p2m3u/common"
+)
+
+// Reads Spotify JSON playlists, either from the "Your Data" export (Playlist1.json) or saved Web API
+// responses: a playlist object, a page of playlist or saved tracks, or a page of tracks.
+type SpotifyReader struct{}
+
+func init() {
+	RegisterReader(common.PlaylistFormat{
+		Name:        "SPOTIFY",
+		Extensions:  []string{"JSON"},
+		Description: "Spotify data export or Web API JSON",
+	}, SpotifyReader{})
+}
+
+// Top level of every supported shape. Only the fields present identify which one a file is.
+type spotifyDocument struct {
+	Playlists []spotifyExportPlaylist `json:"playlists"`
+	Tracks    *spotifyApiPage         `json:"tracks"`
+	Items     []json.RawMessage       `json:"items"`
+}
+
+type spotifyExportPlaylist struct {
+	Name  string `json:"name"`
+	Items []struct {
+		// Null for podcast episodes and local files.
+		Track *struct {
+			TrackName  string `json:"trackName"`
+			ArtistName string `json:"artistName"`
+			AlbumName  string `json:"albumName"`
+		} `json:"track"`
+		// Set for local files added to the playlist.
+		LocalTrack *struct {
+			Uri string `json:"uri"`
+		} `json:"localTrack"`
+	} `json:"items"`
+}
+
+type spotifyApiPage struct {
+	Items []json.RawMessage `json:"items"`
+}
+
+type spotifyApiArtist struct {
+	Name string `json:"name"`
+}
+
+type spotifyApiTrack struct {
+	// "track" or "episode".
+	Type    string             `json:"type"`
+	Name    string             `json:"name"`
+	Artists []spotifyApiArtist `json:"artists"`
+	Album   struct {
+		Name    string             `json:"name"`
+		Artists []spotifyApiArtist `json:"artists"`
+	} `json:"album"`
+	DurationMs  int64 `json:"duration_ms"`
+	TrackNumber int   `json:"track_number"`
+	ExternalIds struct {
+		Isrc string `json:"isrc"`
+	} `json:"external_ids"`
+}
+
+func readSpotifyDocument(filename string) (spotifyDocument, error) {
+	contents, err := os.ReadFile(filename)
+	if err != nil {
+		return spotifyDocument{}, err
+	}
+
+	var document spotifyDocument
+	if err := json.Unmarshal(contents, &document); err != nil {
+		return spotifyDocument{}, err
+	}
+
+	return document, nil
+}
+
+// Returns the names of the playlists in a data export, numbering repeated names e.g. "Mix (2)" so each
+// can be read on its own. Web API responses hold a single playlist, so they have none.
+func (r SpotifyReader) PlaylistNames(filename string) ([]string, error) {
+	document, err := readSpotifyDocument(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	return spotifyPlaylistNames(document.Playlists), nil
+}
+
+func spotifyPlaylistNames(playlists []spotifyExportPlaylist) []string {
+	original := make(map[string]bool)
+	for _, playlist := range playlists {
+		original[playlist.Name] = true
+	}
+
+	var names []string
+	used := make(map[string]bool)
+	for _, playlist := range playlists {
+		name := playlist.Name
+		// Numbered names skip any that another playlist already has.
+		for n := 2; used[name]; n++ {
+			if candidate := fmt.Sprintf("%s (%d)", playlist.Name, n); !original[candidate] && !used[candidate] {
+				name = candidate
+			}
+		}
+
+		used[name] = true
+		names = append(names, name)
+	}
+
+	return names
+}
+
+// Reads a single playlist from a data export, by a name returned from PlaylistNames.
+func (r SpotifyReader) ReadPlaylist(filename string, name string) (Playlist, error) {
+	document, err := readSpotifyDocument(filename)
+	if err != nil {
+		return Playlist{}, err
+	}
+
+	for i, playlistName := range spotifyPlaylistNames(document.Playlists) {
+		if playlistName == name {
+			return readSpotifyExportPlaylist(document.Playlists[i]), nil
+		}
+	}
+
+	return Playlist{}, fmt.Errorf("no playlist named %s in %s", name, filename)
+}
+
+// Reads the file's playlist. Data exports holding several playlists must be read with ReadPlaylist instead.
+func (r SpotifyReader) Read(filename string) (Playlist, error) {
+	document, err := readSpotifyDocument(filename)
+	if err != nil {
+		return Playlist{}, err
+	}
+
+	if len(document.Playlists) == 1 {
+		return readSpotifyExportPlaylist(document.Playlists[0]), nil
+	} else if len(document.Playlists) > 1 {
+		names := spotifyPlaylistNames(document.Playlists)
+		return Playlist{}, fmt.Errorf("%s holds %d playlists, choose one of: %s", filename, len(names), strings.Join(names, ", "))
+	} else if document.Tracks != nil {
+		return readSpotifyApiItems(document.Tracks.Items)
+	} else if document.Items != nil {
+		return readSpotifyApiItems(document.Items)
+	} else {
+		return Playlist{}, errors.New("input JSON is not a Spotify playlist")
+	}
+}
+
+// Podcast episodes and removed tracks are read as empty entries, so they keep their position and are reported as missing.
+func readSpotifyExportPlaylist(playlist spotifyExportPlaylist) Playlist {
+	var fields []ReaderField
+	for _, item := range playlist.Items {
+		if item.Track != nil {
+			fields = append(fields, ReaderField{
+				Title:       item.Track.TrackName,
+				Artist:      item.Track.ArtistName,
+				Album:       item.Track.AlbumName,
+				TrackNumber: -1,
+			})
+		} else if item.LocalTrack != nil {
+			fields = append(fields, parseSpotifyLocalUri(item.LocalTrack.Uri))
+		} else {
+			fields = append(fields, ReaderField{TrackNumber: -1})
+		}
+	}
+
+	return Playlist{fields: fields}
+}
+
+// Reads the metadata of a local file from its URI, spotify:local:artist:album:title:seconds,
+// where each part is URL encoded with + for spaces.
+func parseSpotifyLocalUri(uri string) ReaderField {
+	field := ReaderField{TrackNumber: -1}
+
+	parts := strings.Split(uri, ":")
+	if len(parts) != 6 || parts[0] != "spotify" || parts[1] != "local" {
+		return field
+	}
+
+	unescaped := make([]string, 4)
+	for i, part := range parts[2:] {
+		if value, err := url.QueryUnescape(part); err == nil {
+			unescaped[i] = value
+		} else {
+			unescaped[i] = part
+		}
+	}
+
+	field.Artist = unescaped[0]
+	field.Album = unescaped[1]
+	field.Title = unescaped[2]
+	if seconds, err := strconv.Atoi(unescaped[3]); err == nil {
+		field.Duration = time.Duration(seconds) * time.Second
+	}
+
+	return field
+}
+
+// Reads Web API items, which are either tracks wrapped in a "track" field (playlist and saved tracks)
+// or bare tracks (album tracks and search results).
+func readSpotifyApiItems(items []json.RawMessage) (Playlist, error) {
+	var fields []ReaderField
+	for _, rawItem := range items {
+		var item struct {
+			Track *spotifyApiTrack `json:"track"`
+		}
+		if err := json.Unmarshal(rawItem, &item); err != nil {
+			return Playlist{}, err
+		}
+
+		track := item.Track
+		if track == nil {
+			if err := json.Unmarshal(rawItem, &track); err != nil {
+				return Playlist{}, err
+			}
+		}
+
+		// Removed tracks are null, and podcast episodes cannot be matched against a music library.
+		// Both are kept as empty entries so the rest of the playlist keeps its positions.
+		if track == nil || track.Name == "" || track.Type == "episode" {
+			fields = append(fields, ReaderField{TrackNumber: -1})
+			continue
+		}
+
+		field := ReaderField{
+			Title:       track.Name,
+			Artist:      joinSpotifyArtists(track.Artists),
+			AlbumArtist: joinSpotifyArtists(track.Album.Artists),
+			Album:       track.Album.Name,
+			TrackNumber: -1,
+			Duration:    time.Duration(track.DurationMs) * time.Millisecond,
+			Isrc:        track.ExternalIds.Isrc,
+		}
+		if track.TrackNumber > 0 {
+			field.TrackNumber = track.TrackNumber
+		}
+		fields = append(fields, field)
+	}
+
+	return Playlist{fields: fields}, nil
+}
+
+// Joins artist names the same way multiple artist tags are joined when scanning.
+func joinSpotifyArtists(artists []spotifyApiArtist) string {
+	var names []string
+	for _, artist := range artists {
+		names = append(names, artist.Name)
+	}
+
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,185 @@
+package readers
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func writeSpotifyFile(t *testing.T, contents string) string {
+	t.Helper()
+
+	filename := filepath.Join(t.TempDir(), "spotify.json")
+	if err := os.WriteFile(filename, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return filename
+}
+
+func checkSpotifyFields(t *testing.T, playlist Playlist, expected []ReaderField) {
+	t.Helper()
+
+	fields := playlist.GetFields()
+	if len(fields) != len(expected) {
+		t.Fatalf("expected %d entries, got %d: %+v", len(expected), len(fields), fields)
+	}
+	for i := range expected {
+		if fields[i] != expected[i] {
+			t.Errorf("entry %d = %+v, expected %+v", i, fields[i], expected[i])
+		}
+	}
+}
+
+const spotifyExport = `{"playlists": [
+	{"name": "Mix", "items": [
+		{"track": {"trackName": "Song", "artistName": "Artist", "albumName": "Album"}, "episode": null, "localTrack": null},
+		{"track": null, "episode": {"episodeName": "Episode", "showName": "Show"}, "localTrack": null},
+		{"track": null, "episode": null, "localTrack": {"uri": "spotify:local:The+Artist:An+Album:Gone+%3A+Away:215"}},
+		{"track": null, "episode": null, "localTrack": null}
+	]},
+	{"name": "Mix", "items": [
+		{"track": {"trackName": "Second", "artistName": "Artist", "albumName": "Album"}}
+	]},
+	{"name": "Mix (2)", "items": [
+		{"track": {"trackName": "Third", "artistName": "Artist", "albumName": "Album"}}
+	]}
+]}`
+
+func TestSpotifyExportPlaylistNames(t *testing.T) {
+	filename := writeSpotifyFile(t, spotifyExport)
+
+	// The second "Mix" skips "Mix (2)", which another playlist already has.
+	names, err := SpotifyReader{}.PlaylistNames(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"Mix", "Mix (3)", "Mix (2)"}; !slices.Equal(names, expected) {
+		t.Errorf("expected names %q, got %q", expected, names)
+	}
+
+	for name, title := range map[string]string{"Mix (3)": "Second", "Mix (2)": "Third"} {
+		playlist, err := SpotifyReader{}.ReadPlaylist(filename, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkSpotifyFields(t, playlist, []ReaderField{{Title: title, Artist: "Artist", Album: "Album", TrackNumber: -1}})
+	}
+
+	if _, err := (SpotifyReader{}).ReadPlaylist(filename, "Mix (4)"); err == nil {
+		t.Error("expected an error reading a playlist that does not exist")
+	}
+
+	// Several playlists cannot be read without choosing one.
+	if _, err := (SpotifyReader{}).Read(filename); err == nil {
+		t.Error("expected an error reading an export holding several playlists")
+	}
+}
+
+func TestSpotifyExportItems(t *testing.T) {
+	playlist, err := SpotifyReader{}.ReadPlaylist(writeSpotifyFile(t, spotifyExport), "Mix")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Episodes and removed tracks stay as empty entries so later positions are kept.
+	checkSpotifyFields(t, playlist, []ReaderField{
+		{Title: "Song", Artist: "Artist", Album: "Album", TrackNumber: -1},
+		{TrackNumber: -1},
+		{Title: "Gone : Away", Artist: "The Artist", Album: "An Album", TrackNumber: -1, Duration: 215 * time.Second},
+		{TrackNumber: -1},
+	})
+}
+
+func TestSpotifyExportSinglePlaylist(t *testing.T) {
+	filename := writeSpotifyFile(t, `{"playlists": [{"name": "Only", "items": [
+		{"track": {"trackName": "Song", "artistName": "Artist", "albumName": "Album"}}
+	]}]}`)
+
+	playlist, err := SpotifyReader{}.Read(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSpotifyFields(t, playlist, []ReaderField{{Title: "Song", Artist: "Artist", Album: "Album", TrackNumber: -1}})
+}
+
+const spotifyApiTrackJson = `{
+	"type": "track",
+	"name": "Song",
+	"artists": [{"name": "Artist"}, {"name": "Guest"}],
+	"album": {"name": "Album", "artists": [{"name": "Artist"}]},
+	"duration_ms": 215500,
+	"track_number": 3,
+	"external_ids": {"isrc": "USABC1234567"}
+}`
+
+var spotifyApiField = ReaderField{
+	Title:       "Song",
+	Artist:      "Artist, Guest",
+	AlbumArtist: "Artist",
+	Album:       "Album",
+	TrackNumber: 3,
+	Duration:    215500 * time.Millisecond,
+	Isrc:        "USABC1234567",
+}
+
+func TestSpotifyApiShapes(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+	}{
+		{"playlist object", `{"name": "Playlist", "tracks": {"items": [{"track": ` + spotifyApiTrackJson + `}]}}`},
+		{"playlist tracks page", `{"items": [{"added_at": "2024-01-01T00:00:00Z", "track": ` + spotifyApiTrackJson + `}]}`},
+		{"tracks page", `{"items": [` + spotifyApiTrackJson + `]}`},
+	}
+
+	for _, test := range tests {
+		playlist, err := SpotifyReader{}.Read(writeSpotifyFile(t, test.contents))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		checkSpotifyFields(t, playlist, []ReaderField{spotifyApiField})
+	}
+}
+
+func TestSpotifyApiUnusableItems(t *testing.T) {
+	filename := writeSpotifyFile(t, `{"items": [
+		{"track": null},
+		{"track": {"type": "episode", "name": "Episode"}},
+		{"track": `+spotifyApiTrackJson+`}
+	]}`)
+
+	playlist, err := SpotifyReader{}.Read(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSpotifyFields(t, playlist, []ReaderField{{TrackNumber: -1}, {TrackNumber: -1}, spotifyApiField})
+}
+
+func TestSpotifyNotAPlaylist(t *testing.T) {
+	if _, err := (SpotifyReader{}).Read(writeSpotifyFile(t, `{"name": "Something else"}`)); err == nil {
+		t.Error("expected an error for JSON that is not a Spotify playlist")
+	}
+}
+
+func TestParseSpotifyLocalUri(t *testing.T) {
+	tests := []struct {
+		uri      string
+		expected ReaderField
+	}{
+		{"spotify:local:Artist:Album:Title:180", ReaderField{Artist: "Artist", Album: "Album", Title: "Title", TrackNumber: -1, Duration: 180 * time.Second}},
+		{"spotify:local:The+Artist::A%2BB+%28Live%29:0", ReaderField{Artist: "The Artist", Title: "A+B (Live)", TrackNumber: -1}},
+		{"spotify:local:Artist:Album:Title", ReaderField{TrackNumber: -1}},
+		{"spotify:track:4uLU6hMCjMI75M1A2tKUQC", ReaderField{TrackNumber: -1}},
+		{"", ReaderField{TrackNumber: -1}},
+	}
+
+	for _, test := range tests {
+		if field := parseSpotifyLocalUri(test.uri); field != test.expected {
+			t.Errorf("parseSpotifyLocalUri(%q) = %+v, expected %+v", test.uri, field, test.expected)
+		}
+	}
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	common "dstet.me/p2m3u/common"
+	"go.senan.xyz/taglib"
+)
+
+type ScanCmd struct {
+	SearchDirs []string `arg:"" help:"Directories to search" type:"path" optional:""`
+}
+
+// Joins a search root with a slash-separated path relative to it using the OS separator.
+func osPathJoin(root string, relpath string) string {
+	return filepath.Join(root, filepath.FromSlash(relpath))
+}
+
+// A file that could not be read or walked during a library scan.
+type ScanError struct {
+	Path string
+	Err  error
+}
+
+// Collects per-file errors encountered while scanning so one bad file does not abort the run.
+// Safe for concurrent use by scan workers.
+type ScanReport struct {
+	mu     sync.Mutex
+	Errors []ScanError
+}
+
+func (report *ScanReport) Add(path string, err error) {
+	report.mu.Lock()
+	defer report.mu.Unlock()
+
+	report.Errors = append(report.Errors, ScanError{Path: path, Err: err})
+}
+
+// Prints a summary of the errors encountered during the scan.
+func (report *ScanReport) PrintSummary() {
+	report.mu.Lock()
+	defer report.mu.Unlock()
+
+	if len(report.Errors) < 1 {
+		return
+	}
+
+	fmt.Println("WARNING:", len(report.Errors), "files could not be fully read during scan:")
+	for _, scanErr := range report.Errors {
+		fmt.Println("\t"+scanErr.Path+":", scanErr.Err)
+	}
+}
+
+// Reads song metadata from the file's tags, falling back to the path pattern for missing fields.
+// If the tags cannot be read the song is still returned, with whatever could be inferred from
+// its path (or titled by its filename), along with the error.
+func readSong(filepath string, relpath string, root string, pathPattern *regexp.Regexp, fingerprint bool) (common.Song, error) {
+	song := common.MakeSong()
+	song.Filepath = filepath
+	song.Relpath = relpath
+	song.Root = root
+	song.Filetype = common.GetFileExtension(filepath)
+
+	// M4A may hold either AAC or ALAC, which deserve different bonuses.
+	if song.Filetype == "M4A" {
+		if codec, err := common.Mp4AudioCodec(filepath); err == nil && codec == "alac" {
+			song.Filetype = common.AlacFiletype
+		}
+	}
+
+	if info, err := os.Stat(filepath); err == nil {
+		song.ModTime = info.ModTime()
+	}
+
+	// Audio properties are only used to rank duplicates, so failing to read them is not fatal.
+	if properties, err := taglib.ReadProperties(filepath); err == nil {
+		song.Duration = properties.Length
+		song.Bitrate = properties.Bitrate
+		song.SampleRate = properties.SampleRate
+		song.Channels = properties.Channels
+	}
+	song.BitDepth, _ = common.ReadBitDepth(filepath, song.Filetype)
+
+	tags, err := taglib.ReadTags(filepath)
+	if fingerprint {
+		song.Fingerprint = readFingerprint(filepath, song.Filetype, tags)
+	}
+
+	if err != nil {
+		song.FillFromPath(pathPattern)
+		if song.Title == "" {
+			song.Title = strings.TrimSuffix(path.Base(relpath), path.Ext(relpath))
+		}
+		return song, err
+	}
+
+	if len(tags[taglib.Album]) > 0 {
+		song.Album = tags[taglib.Album][0]
+	}
+
+	if len(tags[taglib.Artist]) > 0 {
+		if len(tags[taglib.Artist]) > 1 {
+			song.Artist = strings.Join(tags[taglib.Artist], ", ")
+		} else {
+			song.Artist = tags[taglib.Artist][0]
+		}
+	}
+
+	if len(tags[taglib.AlbumArtist]) > 0 {
+		song.AlbumArtist = tags[taglib.AlbumArtist][0]
+	}
+
+	if len(tags[taglib.Title]) > 0 {
+		song.Title = tags[taglib.Title][0]
+	}
+
+	if len(tags[taglib.TrackNumber]) > 0 {
+		song.TrackNumber, _ = strconv.Atoi(tags[taglib.TrackNumber][0])
+	}
+
+	song.FillFromPath(pathPattern)
+
+	return song, nil
+}
+
+// Returns a song's fingerprint from its ACOUSTID_FINGERPRINT tag, or computed from its audio when
+// it is a lossless format. Returns nil if neither is possible.
+func readFingerprint(filepath string, filetype string, tags map[string][]string) []uint32 {
+	if len(tags[taglib.AcoustIDFingerprint]) > 0 {
+		if fingerprint, err := common.DecodeFingerprint(tags[taglib.AcoustIDFingerprint][0]); err == nil {
+			return fingerprint
+		}
+	}
+
+	audio, err := common.ReadMonoPcm(filepath, filetype, common.FingerprintDuration)
+	if err != nil {
+		return nil
+	}
+
+	return common.ComputeFingerprint(audio)
+}
+
+// Compiles the configured path pattern, ignoring it if invalid.
+func compileConfigPathPattern(config *common.ConverterConfig) *regexp.Regexp {
+	if config.PathPattern == "" {
+		return nil
+	}
+
+	pathPattern, err := common.CompilePathPattern(config.PathPattern)
+	if err != nil {
+		fmt.Println("ERROR: Invalid path pattern:", err, "Ignoring pattern")
+		return nil
+	}
+
+	return pathPattern
+}
+
+// Compiled include/exclude rules applied while walking a search root.
+type scanFilter struct {
+	filetypes      []string
+	include        []*regexp.Regexp
+	exclude        []*regexp.Regexp
+	skipHidden     bool
+	followSymlinks bool
+}
+
+func makeScanFilter(config *common.ConverterConfig) scanFilter {
+	filter := scanFilter{skipHidden: config.SkipHidden, followSymlinks: config.FollowSymlinks}
+
+	for _, filetype := range config.Filetypes {
+		filter.filetypes = append(filter.filetypes, strings.ToUpper(strings.TrimPrefix(filetype, ".")))
+	}
+	// ALAC is a codec rather than an extension, so make sure its files are still found.
+	if slices.Contains(filter.filetypes, common.AlacFiletype) && !slices.Contains(filter.filetypes, "M4A") {
+		filter.filetypes = append(filter.filetypes, "M4A")
+	}
+
+	compile := func(patterns []string) []*regexp.Regexp {
+		var compiled []*regexp.Regexp
+		for _, pattern := range patterns {
+			if re, err := common.CompileGlob(filepath.ToSlash(pattern)); err == nil {
+				compiled = append(compiled, re)
+			} else {
+				fmt.Println("ERROR: Invalid glob", pattern+":", err, "Ignoring glob")
+			}
+		}
+		return compiled
+	}
+
+	filter.include = compile(config.IncludeGlobs)
+	filter.exclude = compile(config.ExcludeGlobs)
+	return filter
+}
+
+// Returns true if any of the patterns match the path.
+func matchesAny(patterns []*regexp.Regexp, path string) bool {
+	for _, pattern := range patterns {
+		if pattern.MatchString(path) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns true if the directory at relpath should be descended into.
+// Directories are matched with a trailing slash so "*/Podcasts/*" excludes the whole folder.
+func (filter scanFilter) allowDir(relpath string, name string) bool {
+	if filter.skipHidden && strings.HasPrefix(name, ".") && name != "." {
+		return false
+	}
+
+	return !matchesAny(filter.exclude, relpath+"/")
+}
+
+// Returns true if the file at relpath should be scanned.
+func (filter scanFilter) allowFile(relpath string, name string) bool {
+	if filter.skipHidden && strings.HasPrefix(name, ".") {
+		return false
+	}
+
+	if matchesAny(filter.exclude, relpath) {
+		return false
+	}
+
+	return len(filter.include) < 1 || matchesAny(filter.include, relpath)
+}
+
+// Walks dir calling fn for every whitelisted audio file allowed by the config's scan filters,
+// with path relative to dir. Walk errors are recorded in the report and the offending entry skipped.
+func walkLibraryFiles(dir string, config *common.ConverterConfig, report *ScanReport, fn func(path string, dirEntry fs.DirEntry)) {
+	filter := makeScanFilter(config)
+	visited := make(map[string]bool)
+	if realDir, err := filepath.EvalSymlinks(dir); err == nil {
+		visited[realDir] = true
+	}
+
+	walkLibraryDir(dir, "", filepath.Base(dir), filter, visited, report, fn)
+}
+
+// Walks a single directory tree. prefix is prepended to every reported path so files found
+// through followed symlinks stay relative to the search root.
+func walkLibraryDir(dir string, prefix string, reldir string, filter scanFilter, visited map[string]bool, report *ScanReport, fn func(path string, dirEntry fs.DirEntry)) {
+	fileSystem := os.DirFS(dir)
+	fs.WalkDir(fileSystem, ".", func(path string, dirEntry fs.DirEntry, err error) error {
+		if err != nil {
+			// Record the failure and carry on with the rest of the walk.
+			report.Add(osPathJoin(dir, path), err)
+			if dirEntry == nil || dirEntry.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		rootPath := prefix + path
+		relpath := reldir + "/" + rootPath
+
+		if dirEntry.IsDir() {
+			if path != "." && !filter.allowDir(relpath, dirEntry.Name()) {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		if dirEntry.Type()&fs.ModeSymlink != 0 {
+			target := osPathJoin(dir, path)
+			if info, err := os.Stat(target); err == nil && info.IsDir() {
+				if filter.followSymlinks && filter.allowDir(relpath, dirEntry.Name()) {
+					// Track resolved directories so symlink loops are only walked once.
+					if realDir, err := filepath.EvalSymlinks(target); err == nil && !visited[realDir] {
+						visited[realDir] = true
+						walkLibraryDir(target, rootPath+"/", reldir, filter, visited, report, fn)
+					}
+				}
+				return nil
+			}
+		}
+
+		ext := common.GetFileExtension(dirEntry.Name())
+		if ext != dirEntry.Name() && slices.Contains(filter.filetypes, ext) && filter.allowFile(relpath, dirEntry.Name()) {
+			fn(rootPath, dirEntry)
+		}
+		return nil
+	})
+}
+
+// Reads and indexes a single file under the search root dir, unless it is already in the library.
+func addSong(dir string, path string, lib *common.ConverterLibrary, config *common.ConverterConfig, pathPattern *regexp.Regexp, report *ScanReport) {
+	// Relpaths are always stored slash-separated so a db is portable between platforms.
+	relpath := filepath.ToSlash(filepath.Base(dir)) + "/" + path
+	key := common.LibraryKey(dir, path)
+	filepath := osPathJoin(dir, path)
+
+	// Only read song metadata if it has not already been loaded from db file
+	if searchedId := lib.GetId(key); searchedId == -1 {
+		newSong, err := readSong(filepath, relpath, dir, pathPattern, config.Fingerprint)
+		if err != nil {
+			report.Add(filepath, err)
+		}
+		lib.AddSong(key, &newSong, config)
+	}
+}
+
+func addSongsRecursive(dir string, lib *common.ConverterLibrary, config *common.ConverterConfig, report *ScanReport) {
+	pathPattern := compileConfigPathPattern(config)
+
+	// Reading tags dominates scan time, so files are read by a pool of workers.
+	paths := make(chan string)
+	var wg sync.WaitGroup
+	for range runtime.NumCPU() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range paths {
+				addSong(dir, path, lib, config, pathPattern, report)
+			}
+		}()
+	}
+
+	walkLibraryFiles(dir, config, report, func(path string, dirEntry fs.DirEntry) {
+		paths <- path
+	})
+	close(paths)
+	wg.Wait()
+}
+
+// Prunes songs from roots no longer in the config file, then scans every search path into the library.
+// Without search paths in the config file, songs from earlier scans are kept.
+func scanLibrary(library *common.ConverterLibrary, config *common.ConverterConfig) {
+	if config.PathsFromConfig {
+		if pruned := library.PruneRoots(config.Paths); pruned > 0 {
+			fmt.Println("Pruned", pruned, "songs from search paths no longer configured")
+		}
+	}
+
+	fmt.Println("Building database...")
+	var report ScanReport
+	for _, path := range config.Paths {
+		fmt.Println("Reading", path)
+		addSongsRecursive(path, library, config, &report)
+	}
+	report.PrintSummary()
+}
+
+func (cmd *ScanCmd) Run(globals *Globals) error {
+	config := loadConfig(globals, cmd.SearchDirs)
+	if config.Paths == nil {
+		return errors.New("no search paths specified")
+	}
+
+	library := common.MakeLibrary()
+	library.TryReadDbFile(globals.DbFile)
+	scanLibrary(library, &config)
+
+	fmt.Println("Writing database...")
+	library.WriteDbFile(globals.DbFile)
+
+	return nil
+}
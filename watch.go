@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/signal"
+	"slices"
+	"time"
+
+	common "dstet.me/p2m3u/common"
+	readers "dstet.me/p2m3u/readers"
+)
+
+type WatchCmd struct {
+	Inputs       []string      `arg:"" help:"Input playlists, directories of playlists, or globs"`
+	SearchDirs   []string      `short:"s" name:"search-dir" help:"Directories to search in addition to configured paths" type:"path"`
+	OutputDir    string        `short:"d" help:"Directory to write output playlists to" type:"path" default:"."`
+	NameTemplate string        `help:"Output filename template. Available fields are {Name} (input name without extension) and {Ext} (output extension)" default:"{Name}.{Ext}"`
+	InputType    string        `short:"i" help:"Mode to parse input files, inferred from their extensions if unset: ${input_types}" optional:""`
+	OutputType   string        `short:"o" help:"Mode to write output files: ${output_types}" default:"M3U"`
+	Interval     time.Duration `help:"How often to check for changes" default:"5s"`
+
+	OutputFlags `embed:""`
+}
+
+// Modification time and size used to detect changed files between polls.
+type fileStamp struct {
+	ModTime time.Time
+	Size    int64
+}
+
+func makeFileStamp(info fs.FileInfo) fileStamp {
+	return fileStamp{ModTime: info.ModTime(), Size: info.Size()}
+}
+
+// A library file found while polling the search paths.
+type libraryFile struct {
+	Root  string
+	Path  string
+	Stamp fileStamp
+}
+
+// An input playlist being watched, along with the result of its last conversion.
+type watchedPlaylist struct {
+	conv    conversion
+	stamp   fileStamp
+	fields  []readers.ReaderField
+	matched []string
+}
+
+// Returns every library file under the search paths, keyed by library key.
+func snapshotLibrary(config *common.ConverterConfig, report *ScanReport) map[string]libraryFile {
+	files := make(map[string]libraryFile)
+	for _, root := range config.Paths {
+		walkLibraryFiles(root, config, report, func(path string, dirEntry fs.DirEntry) {
+			info, err := dirEntry.Info()
+			if err != nil {
+				report.Add(osPathJoin(root, path), err)
+				return
+			}
+
+			files[common.LibraryKey(root, path)] = libraryFile{Root: root, Path: path, Stamp: makeFileStamp(info)}
+		})
+	}
+
+	return files
+}
+
+// Applies the differences between two library snapshots, returning the number of songs changed.
+func updateLibrary(library *common.ConverterLibrary, config *common.ConverterConfig, previous map[string]libraryFile, current map[string]libraryFile, report *ScanReport) int {
+	pathPattern := compileConfigPathPattern(config)
+	changed := 0
+
+	for key := range previous {
+		if _, exists := current[key]; !exists {
+			if library.RemoveSong(key) {
+				changed++
+			}
+		}
+	}
+
+	for key, file := range current {
+		if old, exists := previous[key]; exists && old.Stamp == file.Stamp {
+			continue
+		}
+
+		// Changed files are re-read from scratch.
+		library.RemoveSong(key)
+		addSong(file.Root, file.Path, library, config, pathPattern, report)
+		changed++
+	}
+
+	return changed
+}
+
+// Returns the filepath of each matched song, or an empty string for unmatched entries.
+func matchedPaths(songList []*common.Song) []string {
+	paths := make([]string, len(songList))
+	for i, song := range songList {
+		if song != nil {
+			paths[i] = song.Filepath
+		}
+	}
+
+	return paths
+}
+
+// Checks the input playlists for changes, returning the playlists that need to be re-read.
+// Playlists are keyed by their input, so each playlist of a data export is watched on its own.
+func pollPlaylists(cmd *WatchCmd, playlists map[batchInput]*watchedPlaylist) (map[batchInput]bool, error) {
+	files, err := expandInputs(cmd.Inputs)
+	if err != nil {
+		return nil, err
+	}
+
+	// Outputs written into a watched directory must not be picked up as inputs, or every write would trigger another.
+	inputs, _ := excludeOutputs(expandPlaylists(files, cmd.InputType), cmd.OutputDir, cmd.NameTemplate, cmd.OutputType)
+
+	for input := range playlists {
+		if !slices.Contains(inputs, input) {
+			fmt.Println("Stopped watching", input.description())
+			delete(playlists, input)
+		}
+	}
+
+	dirty := make(map[batchInput]bool)
+	for _, input := range inputs {
+		info, err := os.Stat(input.Path)
+		if err != nil {
+			continue
+		}
+
+		playlist, exists := playlists[input]
+		if !exists {
+			playlist = &watchedPlaylist{conv: conversion{
+				Input:          input.Path,
+				Output:         inputOutputPath(input, cmd.OutputDir, cmd.NameTemplate, cmd.OutputType),
+				InputType:      cmd.InputType,
+				OutputType:     cmd.OutputType,
+				SourcePlaylist: input.SourcePlaylist,
+				OutputFlags:    cmd.OutputFlags,
+			}}
+			playlists[input] = playlist
+		}
+
+		if stamp := makeFileStamp(info); !exists || stamp != playlist.stamp {
+			playlist.stamp = stamp
+			dirty[input] = true
+		}
+	}
+
+	return dirty, nil
+}
+
+func (cmd *WatchCmd) Run(globals *Globals) error {
+	if err := os.MkdirAll(cmd.OutputDir, 0755); err != nil {
+		return err
+	}
+
+	config := loadConfig(globals, cmd.SearchDirs)
+	library, err := prepareLibrary(globals, &config, true)
+	if err != nil {
+		return err
+	}
+
+	var report ScanReport
+	libraryFiles := snapshotLibrary(&config, &report)
+	playlists := make(map[batchInput]*watchedPlaylist)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	fmt.Println("Watching for changes, press Ctrl+C to stop...")
+	libraryChanged := false
+	for {
+		dirty, err := pollPlaylists(cmd, playlists)
+		if err != nil {
+			return err
+		}
+
+		for input, playlist := range playlists {
+			if !dirty[input] && !libraryChanged {
+				continue
+			}
+
+			if dirty[input] {
+				fields, err := readPlaylist(playlist.conv, &config)
+				if err != nil {
+					// The file may still be syncing, so try again on the next change.
+					fmt.Println("ERROR: Failed to read", input.description()+":", err)
+					continue
+				}
+				playlist.fields = fields
+			}
+
+			songList := matchSongsInList(&config, playlist.fields, library)
+			paths := matchedPaths(songList)
+
+			// Only rewrite outputs whose matches actually changed.
+			if dirty[input] || !slices.Equal(paths, playlist.matched) {
+				if err := writePlaylist(playlist.conv, &config, library, playlist.fields, songList); err != nil {
+					fmt.Println("ERROR: Failed to write", playlist.conv.Output+":", err)
+					continue
+				}
+				playlist.matched = paths
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			fmt.Println("Stopping watch")
+			return nil
+		case <-time.After(cmd.Interval):
+		}
+
+		report = ScanReport{}
+		current := snapshotLibrary(&config, &report)
+		changed := updateLibrary(library, &config, libraryFiles, current, &report)
+		libraryFiles = current
+		libraryChanged = changed > 0
+
+		if libraryChanged {
+			fmt.Println("Library changed:", changed, "songs updated")
+			report.PrintSummary()
+
+			fmt.Println("Writing database...")
+			library.WriteDbFile(globals.DbFile)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package writers
+
+import (
+	"encoding/xml"
+
+	common "dstet.me/p2m3u/common"
+)
+
+type jellyfinPlaylist struct {
+	XMLName    xml.Name `xml:"Item"`
+	LockData   bool     `xml:"LockData"`
+	LocalTitle string   `xml:"LocalTitle,omitempty"`
+	// Items, or XML comments for unmatched entries.
+	Items             []any  `xml:"PlaylistItems>PlaylistItem"`
+	PlaylistMediaType string `xml:"PlaylistMediaType"`
+}
+
+type jellyfinItem struct {
+	Path string `xml:"Path"`
+}
+
+// Writes Jellyfin/Emby playlist.xml files. These servers need paths as they see them,
+// so use an absolute or prefix path mode.
+type JellyfinWriter struct{}
+
+func init() {
+	RegisterWriter(common.PlaylistFormat{
+		Name:        "JELLYFIN",
+		Extensions:  []string{"XML"},
+		Description: "Jellyfin/Emby playlist.xml",
+	}, JellyfinWriter{})
+}
+
+func (w JellyfinWriter) Write(filename string, playlist Playlist) error {
+	jellyfin := jellyfinPlaylist{
+		LocalTitle:        playlist.Name,
+		PlaylistMediaType: "Audio",
+	}
+
+	for i, song := range playlist.Songs {
+		if song != nil {
+			jellyfin.Items = append(jellyfin.Items, jellyfinItem{Path: playlist.Paths.FormatPath(song)})
+		} else if playlist.MissingMode == CommentMissing {
+			jellyfin.Items = append(jellyfin.Items, xmlMissingComment(playlist.missingDescription(i)))
+		} else if playlist.MissingMode == PlaceholderMissing {
+			jellyfin.Items = append(jellyfin.Items, jellyfinItem{Path: playlist.Placeholder})
+		}
+	}
+
+	output, err := xml.MarshalIndent(jellyfin, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(filename, []byte(xml.Header+string(output)+"\n"))
+}
@@ -1,26 +1,34 @@
 package writers
 
 import (
-	"os"
 	"strings"
 
 	common "dstet.me/p2m3u/common"
 )
 
-func WriteM3U(filename string, list []*common.Song) {
+// Writes plain M3U playlists, one path per line.
+type M3UWriter struct{}
+
+func init() {
+	RegisterWriter(common.PlaylistFormat{
+		Name:        "M3U",
+		Extensions:  []string{"M3U"},
+		Description: "one path per line",
+	}, M3UWriter{})
+}
+
+func (w M3UWriter) Write(filename string, playlist Playlist) error {
 	var builder strings.Builder
-	for _, song := range list {
+	for i, song := range playlist.Songs {
 		if song != nil {
-			builder.WriteString(song.Relpath)
+			builder.WriteString(playlist.Paths.FormatPath(song))
 			builder.WriteString("\n")
+		} else if playlist.MissingMode == CommentMissing {
+			builder.WriteString("# MISSING: " + sanitizeLine(playlist.missingDescription(i)) + "\n")
+		} else if playlist.MissingMode == PlaceholderMissing {
+			builder.WriteString(sanitizeLine(playlist.Placeholder) + "\n")
 		}
 	}
 
-	outputFile, err := os.Create(filename)
-	if err != nil {
-		panic(err)
-	}
-
-	outputFile.WriteString(builder.String())
-	outputFile.Close()
+	return writeFileAtomic(filename, []byte(builder.String()))
 }
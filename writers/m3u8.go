@@ -0,0 +1,43 @@
+package writers
+
+import (
+	"strconv"
+	"strings"
+
+	common "dstet.me/p2m3u/common"
+)
+
+// Writes extended M3U playlists with #EXTINF durations and titles, always encoded as UTF-8.
+type ExtendedM3UWriter struct{}
+
+func init() {
+	RegisterWriter(common.PlaylistFormat{
+		Name:        "M3U8",
+		Extensions:  []string{"M3U8"},
+		Description: "extended M3U with durations and titles",
+	}, ExtendedM3UWriter{})
+}
+
+// The #PLAYLIST line is only written if the playlist has a name.
+func (w ExtendedM3UWriter) Write(filename string, playlist Playlist) error {
+	var builder strings.Builder
+	builder.WriteString("#EXTM3U\n")
+	if playlist.Name != "" {
+		builder.WriteString("#PLAYLIST:" + sanitizeLine(playlist.Name) + "\n")
+	}
+
+	for i, song := range playlist.Songs {
+		if song != nil {
+			builder.WriteString("#EXTINF:" + strconv.Itoa(durationSeconds(song)) + "," + sanitizeLine(displayTitle(song)) + "\n")
+			builder.WriteString(strings.ToValidUTF8(playlist.Paths.FormatPath(song), "\uFFFD"))
+			builder.WriteString("\n")
+		} else if playlist.MissingMode == CommentMissing {
+			builder.WriteString("# MISSING: " + sanitizeLine(playlist.missingDescription(i)) + "\n")
+		} else if playlist.MissingMode == PlaceholderMissing {
+			builder.WriteString("#EXTINF:-1," + sanitizeLine(playlist.missingDescription(i)) + "\n")
+			builder.WriteString(sanitizeLine(playlist.Placeholder) + "\n")
+		}
+	}
+
+	return writeFileAtomic(filename, []byte(builder.String()))
+}
@@ -0,0 +1,140 @@
+package writers
+
+import (
+	"net/url"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	common "dstet.me/p2m3u/common"
+)
+
+// Separator styles for paths written to playlists.
+const NativePathStyle = "native"
+const WindowsPathStyle = "windows"
+const PosixPathStyle = "posix"
+
+var PathStyles = []string{
+	NativePathStyle,
+	WindowsPathStyle,
+	PosixPathStyle,
+}
+
+// Bases for paths written to playlists.
+// Library paths are relative to the parent of the song's search root, relative paths to the playlist's directory.
+const LibraryPathMode = "library"
+const RelativePathMode = "relative"
+const AbsolutePathMode = "absolute"
+const PrefixPathMode = "prefix"
+
+var PathModes = []string{
+	LibraryPathMode,
+	RelativePathMode,
+	AbsolutePathMode,
+	PrefixPathMode,
+}
+
+// Controls how song paths are rendered in output playlists.
+type PathOptions struct {
+	Style string
+	Mode  string
+	// Directory the playlist is written to, used by the relative mode.
+	OutputDir string
+	// Map of absolute path prefixes to their replacements, used by the prefix mode.
+	Prefixes map[string]string
+}
+
+// Returns the separator for the path style, using the current platform's for native.
+func (options PathOptions) Separator() string {
+	switch options.Style {
+	case WindowsPathStyle:
+		return "\\"
+	case PosixPathStyle:
+		return "/"
+	default:
+		if runtime.GOOS == "windows" {
+			return "\\"
+		}
+		return "/"
+	}
+}
+
+// Returns the song's path for the path mode as a slash-separated path.
+func (options PathOptions) slashPath(song *common.Song) string {
+	switch options.Mode {
+	case RelativePathMode:
+		// Paths on another volume cannot be made relative, so they are written in full.
+		if relpath, err := filepath.Rel(options.OutputDir, absolutePath(song)); err == nil {
+			return filepath.ToSlash(relpath)
+		}
+		return filepath.ToSlash(absolutePath(song))
+	case AbsolutePathMode:
+		return filepath.ToSlash(absolutePath(song))
+	case PrefixPathMode:
+		return replacePrefix(filepath.ToSlash(absolutePath(song)), options.Prefixes)
+	default:
+		return song.Relpath
+	}
+}
+
+// Renders a song's path for the path mode with the separators of the target platform.
+func (options PathOptions) FormatPath(song *common.Song) string {
+	return strings.ReplaceAll(options.slashPath(song), "/", options.Separator())
+}
+
+// Renders a song's path for the path mode as a URI, for formats that store locations as URIs.
+// Absolute paths become file URIs, while relative paths stay relative references.
+func (options PathOptions) FormatURI(song *common.Song) string {
+	slashPath := options.slashPath(song)
+	if isAbsoluteSlashPath(slashPath) {
+		if !strings.HasPrefix(slashPath, "/") {
+			// Windows drive paths need a leading slash, e.g. file:///Z:/Music.
+			slashPath = "/" + slashPath
+		}
+		return (&url.URL{Scheme: "file", Path: slashPath}).String()
+	}
+
+	return (&url.URL{Path: slashPath}).String()
+}
+
+// Returns true for slash-separated paths starting at a root or a Windows drive letter.
+func isAbsoluteSlashPath(path string) bool {
+	if strings.HasPrefix(path, "/") {
+		return true
+	}
+
+	return len(path) > 2 && path[1] == ':' && path[2] == '/' &&
+		(('a' <= path[0] && path[0] <= 'z') || ('A' <= path[0] && path[0] <= 'Z'))
+}
+
+// Returns the song's absolute path on this machine.
+func absolutePath(song *common.Song) string {
+	if absPath, err := filepath.Abs(song.Filepath); err == nil {
+		return absPath
+	}
+	return song.Filepath
+}
+
+// Replaces the longest prefix of path found in the map, only matching whole path components.
+// Returns the path unchanged if no prefix matches.
+func replacePrefix(path string, prefixes map[string]string) string {
+	var bestFrom string
+	var bestTo string
+	for from, to := range prefixes {
+		from = strings.TrimSuffix(filepath.ToSlash(from), "/")
+		if len(from) <= len(bestFrom) {
+			continue
+		}
+
+		if path == from || strings.HasPrefix(path, from+"/") {
+			bestFrom = from
+			bestTo = strings.TrimSuffix(filepath.ToSlash(to), "/")
+		}
+	}
+
+	if bestFrom == "" {
+		return path
+	}
+
+	return bestTo + strings.TrimPrefix(path, bestFrom)
+}
@@ -0,0 +1,49 @@
+package writers
+
+import (
+	"strconv"
+	"strings"
+
+	common "dstet.me/p2m3u/common"
+)
+
+// Writes version 2 PLS playlists with the title and length of each song.
+type PLSWriter struct{}
+
+func init() {
+	RegisterWriter(common.PlaylistFormat{
+		Name:        "PLS",
+		Extensions:  []string{"PLS"},
+		Description: "PLS with titles and lengths",
+	}, PLSWriter{})
+}
+
+func (w PLSWriter) Write(filename string, playlist Playlist) error {
+	var builder strings.Builder
+	builder.WriteString("[playlist]\n")
+
+	entries := 0
+	writeEntry := func(path string, title string, length int) {
+		entries++
+		index := strconv.Itoa(entries)
+		builder.WriteString("File" + index + "=" + sanitizeLine(path) + "\n")
+		builder.WriteString("Title" + index + "=" + sanitizeLine(title) + "\n")
+		builder.WriteString("Length" + index + "=" + strconv.Itoa(length) + "\n")
+	}
+
+	for i, song := range playlist.Songs {
+		if song != nil {
+			writeEntry(playlist.Paths.FormatPath(song), displayTitle(song), durationSeconds(song))
+		} else if playlist.MissingMode == CommentMissing {
+			// PLS files are INI files, which use semicolons for comments.
+			builder.WriteString("; MISSING: " + sanitizeLine(playlist.missingDescription(i)) + "\n")
+		} else if playlist.MissingMode == PlaceholderMissing {
+			writeEntry(playlist.Placeholder, playlist.missingDescription(i), -1)
+		}
+	}
+
+	builder.WriteString("NumberOfEntries=" + strconv.Itoa(entries) + "\n")
+	builder.WriteString("Version=2\n")
+
+	return writeFileAtomic(filename, []byte(builder.String()))
+}
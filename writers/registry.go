@@ -0,0 +1,106 @@
+package writers
+
+import (
+	"slices"
+	"strings"
+
+	common "dstet.me/p2m3u/common"
+)
+
+// Ways of writing entries that could not be matched.
+const DropMissing = "drop"
+const CommentMissing = "comment"
+const PlaceholderMissing = "placeholder"
+
+var MissingModes = []string{
+	DropMissing,
+	CommentMissing,
+	PlaceholderMissing,
+}
+
+// Songs to write to a playlist. Unmatched entries are nil.
+type Playlist struct {
+	// Title for formats that have one, left out when empty.
+	Name string
+	// Name of the input playlist, for formats that need a name when none was given.
+	SourceName string
+	Songs      []*common.Song
+	// Descriptions of unmatched entries, e.g. "Artist - Album - Title", at the same positions as Songs.
+	Missing []string
+	// How unmatched entries are written. Formats without comments skip them in the comment mode.
+	MissingMode string
+	// Path written in place of unmatched entries in the placeholder mode.
+	Placeholder string
+	Paths       PathOptions
+}
+
+// Returns the description of the unmatched entry at position i.
+func (playlist Playlist) missingDescription(i int) string {
+	if i < len(playlist.Missing) {
+		return playlist.Missing[i]
+	}
+
+	return ""
+}
+
+// Writes a playlist file in a single format.
+type PlaylistWriter interface {
+	Write(filename string, playlist Playlist) error
+}
+
+type registeredWriter struct {
+	format common.PlaylistFormat
+	writer PlaylistWriter
+}
+
+var writerRegistry []registeredWriter
+
+// Adds a writer for the format. Formats register themselves from their own file's init function.
+func RegisterWriter(format common.PlaylistFormat, writer PlaylistWriter) {
+	writerRegistry = append(writerRegistry, registeredWriter{format: format, writer: writer})
+}
+
+// Returns the writer and format for a type name or file extension. Type names take priority over extensions.
+// Extensions written by several formats, like XML, match none of them, so the type has to be named.
+func LookupWriter(typeName string) (PlaylistWriter, common.PlaylistFormat, bool) {
+	for _, registered := range writerRegistry {
+		if registered.format.Name == strings.ToUpper(typeName) {
+			return registered.writer, registered.format, true
+		}
+	}
+
+	var matches []registeredWriter
+	for _, registered := range writerRegistry {
+		if registered.format.Matches(typeName) {
+			matches = append(matches, registered)
+		}
+	}
+
+	if len(matches) == 1 {
+		return matches[0].writer, matches[0].format, true
+	}
+
+	return nil, common.PlaylistFormat{}, false
+}
+
+// Returns the names of the formats written to files with the extension.
+func WriterNamesForExtension(ext string) []string {
+	var names []string
+	for _, registered := range writerRegistry {
+		if slices.Contains(registered.format.Extensions, strings.ToUpper(ext)) {
+			names = append(names, registered.format.Name)
+		}
+	}
+
+	return names
+}
+
+// Returns every registered output format, in registration order.
+func WriterFormats() []common.PlaylistFormat {
+	var formats []common.PlaylistFormat
+	for _, registered := range writerRegistry {
+		formats = append(formats, registered.format)
+	}
+
+	return formats
+}
@@ -0,0 +1,111 @@
+package writers
+
+import (
+	"encoding/xml"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	common "dstet.me/p2m3u/common"
+)
+
+type rhythmboxPlaylists struct {
+	XMLName xml.Name `xml:"rhythmdb-playlists"`
+	// Playlists kept from an existing file, and the written playlist.
+	Playlists []any `xml:"playlist"`
+}
+
+// Playlist read from an existing file, kept exactly as it was. Automatic playlists hold
+// queries rather than locations, so their contents are not parsed.
+type rhythmboxExistingPlaylist struct {
+	Attrs    []xml.Attr `xml:",any,attr"`
+	Contents string     `xml:",innerxml"`
+}
+
+type rhythmboxExistingPlaylists struct {
+	XMLName   xml.Name                    `xml:"rhythmdb-playlists"`
+	Playlists []rhythmboxExistingPlaylist `xml:"playlist"`
+}
+
+type rhythmboxPlaylist struct {
+	Name            string `xml:"name,attr"`
+	ShowBrowser     bool   `xml:"show-browser,attr"`
+	BrowserPosition int    `xml:"browser-position,attr"`
+	SearchType      string `xml:"search-type,attr"`
+	Type            string `xml:"type,attr"`
+	// Locations, or XML comments for unmatched entries.
+	Locations []any `xml:"location"`
+}
+
+// Writes static playlists in Rhythmbox's playlists.xml. Other playlists already in the file are kept,
+// and one with the same name is replaced. Rhythmbox only plays file URIs, so use an absolute or prefix path mode.
+type RhythmboxWriter struct{}
+
+func init() {
+	RegisterWriter(common.PlaylistFormat{
+		Name:         "RHYTHMBOX",
+		Extensions:   []string{"XML"},
+		Description:  "Rhythmbox playlists.xml, keeping its other playlists",
+		MergesOutput: true,
+	}, RhythmboxWriter{})
+}
+
+// Playlists without a name are named after their input, as Rhythmbox requires one.
+func (w RhythmboxWriter) Write(filename string, playlist Playlist) error {
+	name := playlist.Name
+	if name == "" {
+		name = playlist.SourceName
+	}
+
+	var rhythmbox rhythmboxPlaylists
+	if data, err := os.ReadFile(filename); err == nil {
+		var existing rhythmboxExistingPlaylists
+		if err := xml.Unmarshal(data, &existing); err != nil {
+			return errors.New("existing file is not a Rhythmbox playlists.xml: " + err.Error())
+		}
+
+		for _, existingPlaylist := range existing.Playlists {
+			if !existingPlaylist.hasName(name) {
+				rhythmbox.Playlists = append(rhythmbox.Playlists, existingPlaylist)
+			}
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	static := rhythmboxPlaylist{
+		Name:            name,
+		BrowserPosition: 180,
+		SearchType:      "search-match",
+		Type:            "static",
+	}
+	for i, song := range playlist.Songs {
+		if song != nil {
+			static.Locations = append(static.Locations, playlist.Paths.FormatURI(song))
+		} else if playlist.MissingMode == CommentMissing {
+			static.Locations = append(static.Locations, xmlMissingComment(playlist.missingDescription(i)))
+		} else if playlist.MissingMode == PlaceholderMissing {
+			static.Locations = append(static.Locations, (&url.URL{Path: filepath.ToSlash(playlist.Placeholder)}).String())
+		}
+	}
+	rhythmbox.Playlists = append(rhythmbox.Playlists, static)
+
+	output, err := xml.MarshalIndent(rhythmbox, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(filename, []byte(xml.Header+string(output)+"\n"))
+}
+
+// Returns true if the playlist's name attribute matches.
+func (playlist rhythmboxExistingPlaylist) hasName(name string) bool {
+	for _, attr := range playlist.Attrs {
+		if attr.Name.Local == "name" {
+			return attr.Value == name
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,82 @@
+package writers
+
+import (
+	"encoding/xml"
+	"strconv"
+
+	common "dstet.me/p2m3u/common"
+)
+
+type wplPlaylist struct {
+	XMLName xml.Name `xml:"smil"`
+	Head    struct {
+		Meta  []wplMeta `xml:"meta"`
+		Title string    `xml:"title,omitempty"`
+	} `xml:"head"`
+	// Media entries, or XML comments for unmatched entries.
+	Media []any `xml:"body>seq>media"`
+}
+
+type wplMeta struct {
+	Name    string `xml:"name,attr"`
+	Content string `xml:"content,attr"`
+}
+
+// Media entry with the metadata attributes written by Windows Media Player 12.
+type wplMedia struct {
+	Src         string `xml:"src,attr"`
+	AlbumTitle  string `xml:"albumTitle,attr,omitempty"`
+	AlbumArtist string `xml:"albumArtist,attr,omitempty"`
+	TrackTitle  string `xml:"trackTitle,attr,omitempty"`
+	TrackArtist string `xml:"trackArtist,attr,omitempty"`
+	// Length in milliseconds.
+	Duration int64 `xml:"duration,attr,omitempty"`
+}
+
+// Writes Windows Media Player WPL playlists, which Kodi can also read.
+type WPLWriter struct{}
+
+func init() {
+	RegisterWriter(common.PlaylistFormat{
+		Name:        "WPL",
+		Extensions:  []string{"WPL"},
+		Description: "Windows Media Player playlist",
+	}, WPLWriter{})
+}
+
+func (w WPLWriter) Write(filename string, playlist Playlist) error {
+	var wpl wplPlaylist
+	wpl.Head.Title = playlist.Name
+
+	itemCount := 0
+	for i, song := range playlist.Songs {
+		if song != nil {
+			itemCount++
+			wpl.Media = append(wpl.Media, wplMedia{
+				Src:         playlist.Paths.FormatPath(song),
+				AlbumTitle:  song.Album,
+				AlbumArtist: song.AlbumArtist,
+				TrackTitle:  song.Title,
+				TrackArtist: song.Artist,
+				Duration:    song.Duration.Milliseconds(),
+			})
+		} else if playlist.MissingMode == CommentMissing {
+			wpl.Media = append(wpl.Media, xmlMissingComment(playlist.missingDescription(i)))
+		} else if playlist.MissingMode == PlaceholderMissing {
+			itemCount++
+			wpl.Media = append(wpl.Media, wplMedia{Src: playlist.Placeholder, TrackTitle: playlist.missingDescription(i)})
+		}
+	}
+
+	wpl.Head.Meta = []wplMeta{
+		{Name: "Generator", Content: "p2m3u"},
+		{Name: "ItemCount", Content: strconv.Itoa(itemCount)},
+	}
+
+	output, err := xml.MarshalIndent(wpl, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(filename, []byte("<?wpl version=\"1.0\"?>\n"+string(output)+"\n"))
+}
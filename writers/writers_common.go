@@ -0,0 +1,100 @@
+package writers
+
+import (
+	"encoding/xml"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	common "dstet.me/p2m3u/common"
+)
+
+// Writes data to a temporary file next to filename, then renames it into place once fully written,
+// so a failed write never leaves a truncated playlist and keeps the existing file's contents.
+func writeFileAtomic(filename string, data []byte) error {
+	return writeFileAtomicWith(filename, func(writer io.Writer) error {
+		_, err := writer.Write(data)
+		return err
+	})
+}
+
+// Like writeFileAtomic, but streams the contents from write, which may fail partway through.
+func writeFileAtomicWith(filename string, write func(writer io.Writer) error) error {
+	tempFile, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tempName := tempFile.Name()
+
+	if err := write(tempFile); err != nil {
+		tempFile.Close()
+		os.Remove(tempName)
+		return err
+	}
+
+	if err := tempFile.Sync(); err != nil {
+		tempFile.Close()
+		os.Remove(tempName)
+		return err
+	}
+
+	if err := tempFile.Close(); err != nil {
+		os.Remove(tempName)
+		return err
+	}
+
+	// Temporary files are only readable by their owner, so keep the permissions of the file being replaced.
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(filename); err == nil {
+		mode = info.Mode().Perm()
+	}
+	if err := os.Chmod(tempName, mode); err != nil {
+		os.Remove(tempName)
+		return err
+	}
+
+	if err := os.Rename(tempName, filename); err != nil {
+		os.Remove(tempName)
+		return err
+	}
+
+	return nil
+}
+
+// An XML comment standing in for an unmatched entry in a list of elements.
+type xmlMissingComment string
+
+func (comment xmlMissingComment) MarshalXML(encoder *xml.Encoder, start xml.StartElement) error {
+	// XML comments cannot contain "--".
+	text := strings.ReplaceAll(string(comment), "--", "- -")
+	return encoder.EncodeToken(xml.Comment(" MISSING: " + text + " "))
+}
+
+// Returns the song's length in whole seconds, or -1 if unknown.
+func durationSeconds(song *common.Song) int {
+	if song.Duration <= 0 {
+		return -1
+	}
+
+	return int(song.Duration.Round(time.Second).Seconds())
+}
+
+// Returns "Artist - Title", falling back to whichever is known or the file name.
+func displayTitle(song *common.Song) string {
+	if song.Artist != "" && song.Title != "" {
+		return song.Artist + " - " + song.Title
+	} else if song.Title != "" {
+		return song.Title
+	} else {
+		return path.Base(song.Relpath)
+	}
+}
+
+// Replaces invalid UTF-8 and line breaks, which would otherwise corrupt the playlist.
+func sanitizeLine(value string) string {
+	value = strings.ToValidUTF8(value, "\uFFFD")
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(value)
+}
@@ -0,0 +1,107 @@
+package writers
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Fails the test if writeFileAtomic left temporary files in dir.
+func checkNoTempFiles(t *testing.T, dir string) {
+	t.Helper()
+
+	matches, err := filepath.Glob(filepath.Join(dir, ".*.tmp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) > 0 {
+		t.Errorf("temporary files left behind: %v", matches)
+	}
+}
+
+func TestWriteFileAtomicReplaces(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "playlist.m3u")
+
+	if err := writeFileAtomic(filename, []byte("first\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFileAtomic(filename, []byte("second\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	if contents, err := os.ReadFile(filename); err != nil || string(contents) != "second\n" {
+		t.Errorf("expected the file to be replaced, got %q (%v)", contents, err)
+	}
+	checkNoTempFiles(t, dir)
+}
+
+func TestWriteFileAtomicFailedWriteKeepsFile(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "playlist.m3u")
+	if err := os.WriteFile(filename, []byte("existing\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	writeErr := errors.New("encoding failed")
+	err := writeFileAtomicWith(filename, func(writer io.Writer) error {
+		// Part of the new contents is written before the failure.
+		writer.Write([]byte("partial"))
+		return writeErr
+	})
+	if !errors.Is(err, writeErr) {
+		t.Errorf("expected the write error, got %v", err)
+	}
+
+	if contents, err := os.ReadFile(filename); err != nil || string(contents) != "existing\n" {
+		t.Errorf("expected the existing file to be unchanged, got %q (%v)", contents, err)
+	}
+	checkNoTempFiles(t, dir)
+}
+
+func TestWriteFileAtomicFailedRename(t *testing.T) {
+	dir := t.TempDir()
+	// A directory that is not empty cannot be replaced by a file.
+	filename := filepath.Join(dir, "playlist.m3u")
+	if err := os.MkdirAll(filepath.Join(filename, "inside"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeFileAtomic(filename, []byte("new\n")); err == nil {
+		t.Error("expected an error replacing a directory")
+	}
+
+	if info, err := os.Stat(filename); err != nil || !info.IsDir() {
+		t.Errorf("expected the directory to be left in place (%v)", err)
+	}
+	checkNoTempFiles(t, dir)
+}
+
+func TestWriteFileAtomicKeepsPermissions(t *testing.T) {
+	dir := t.TempDir()
+
+	existing := filepath.Join(dir, "private.m3u")
+	if err := os.WriteFile(existing, []byte("existing\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(existing, 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFileAtomic(existing, []byte("new\n")); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(existing); err != nil || info.Mode().Perm() != 0640 {
+		t.Errorf("expected permissions 0640 to be kept, got %v (%v)", info.Mode().Perm(), err)
+	}
+
+	// New files get the usual permissions rather than the temporary file's owner only ones.
+	created := filepath.Join(dir, "new.m3u")
+	if err := writeFileAtomic(created, []byte("new\n")); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(created); err != nil || info.Mode().Perm() != 0644 {
+		t.Errorf("expected new file permissions 0644, got %v (%v)", info.Mode().Perm(), err)
+	}
+}
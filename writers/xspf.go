@@ -0,0 +1,81 @@
+package writers
+
+import (
+	"encoding/xml"
+	"net/url"
+	"path/filepath"
+
+	common "dstet.me/p2m3u/common"
+)
+
+type xspfPlaylist struct {
+	XMLName xml.Name `xml:"playlist"`
+	Version string   `xml:"version,attr"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Title   string   `xml:"title,omitempty"`
+	// The trackList element is required even if the playlist is empty.
+	TrackList struct {
+		// Tracks, or XML comments for unmatched entries.
+		Tracks []any `xml:"track"`
+	} `xml:"trackList"`
+}
+
+type xspfTrack struct {
+	Location string `xml:"location"`
+	Title    string `xml:"title,omitempty"`
+	Creator  string `xml:"creator,omitempty"`
+	Album    string `xml:"album,omitempty"`
+	TrackNum int    `xml:"trackNum,omitempty"`
+	// Length in milliseconds.
+	Duration int64 `xml:"duration,omitempty"`
+}
+
+// Writes XSPF playlists, with song locations as URIs.
+type XSPFWriter struct{}
+
+func init() {
+	RegisterWriter(common.PlaylistFormat{
+		Name:        "XSPF",
+		Extensions:  []string{"XSPF"},
+		Description: "XSPF with full metadata",
+	}, XSPFWriter{})
+}
+
+func (w XSPFWriter) Write(filename string, playlist Playlist) error {
+	xspf := xspfPlaylist{
+		Version: "1",
+		Xmlns:   "http://xspf.org/ns/0/",
+		Title:   playlist.Name,
+	}
+
+	for i, song := range playlist.Songs {
+		if song != nil {
+			track := xspfTrack{
+				Location: playlist.Paths.FormatURI(song),
+				Title:    song.Title,
+				Creator:  song.Artist,
+				Album:    song.Album,
+				Duration: song.Duration.Milliseconds(),
+			}
+			// Unknown track numbers are stored as -1, and XSPF only allows positive ones.
+			if song.TrackNumber > 0 {
+				track.TrackNum = song.TrackNumber
+			}
+			xspf.TrackList.Tracks = append(xspf.TrackList.Tracks, track)
+		} else if playlist.MissingMode == CommentMissing {
+			xspf.TrackList.Tracks = append(xspf.TrackList.Tracks, xmlMissingComment(playlist.missingDescription(i)))
+		} else if playlist.MissingMode == PlaceholderMissing {
+			xspf.TrackList.Tracks = append(xspf.TrackList.Tracks, xspfTrack{
+				Location: (&url.URL{Path: filepath.ToSlash(playlist.Placeholder)}).String(),
+				Title:    playlist.missingDescription(i),
+			})
+		}
+	}
+
+	output, err := xml.MarshalIndent(xspf, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(filename, []byte(xml.Header+string(output)+"\n"))
+}